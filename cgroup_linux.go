@@ -0,0 +1,199 @@
+//go:build linux
+
+package crosspty
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var cgroupSeq atomic.Uint64
+
+// cloneIntoCgroup reports whether clone3() with CLONE_INTO_CGROUP (Linux
+// 5.7+) is available. clone3() may also be blocked by seccomp, e.g. in older
+// container runtimes, which makes it return ENOSYS.
+var cloneIntoCgroup = sync.OnceValue(func() bool {
+	// A NULL argument is rejected with EINVAL before anything else happens.
+	_, _, errno := unix.Syscall(unix.SYS_CLONE3, 0, 0, 0)
+	if errno == unix.ENOSYS {
+		return false
+	}
+
+	var uts unix.Utsname
+	if unix.Uname(&uts) != nil {
+		return false
+	}
+	var major, minor int
+	fmt.Sscanf(unix.ByteSliceToString(uts.Release[:]), "%d.%d", &major, &minor)
+	return major > 5 || (major == 5 && minor >= 7)
+})
+
+// currentCgroup returns the cgroup v2 directory of the current process.
+func currentCgroup() (string, error) {
+	mount, err := cgroup2Mount()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(mount, path), nil
+		}
+	}
+	return "", errors.New("crosspty: not in a cgroup v2 hierarchy")
+}
+
+// cgroup2Mount returns the mount point of the cgroup v2 hierarchy, usually
+// /sys/fs/cgroup, or /sys/fs/cgroup/unified on hybrid systems.
+func cgroup2Mount() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// "36 35 0:30 / /sys/fs/cgroup rw,... shared:9 - cgroup2 cgroup2 rw"
+		pre, post, ok := strings.Cut(sc.Text(), " - ")
+		fields := strings.Fields(pre)
+		if ok && len(fields) >= 5 && strings.HasPrefix(post, "cgroup2 ") {
+			return fields[4], nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("crosspty: cgroup v2 is not mounted")
+}
+
+// setupCgroup creates the cgroup for KillModeKillCgroup, and arranges for
+// cmd to start in it if the kernel supports it.
+func (p *ptyUnix) setupCgroup(cmd *exec.Cmd) error {
+	p.cgroupFD = -1
+	if p.closeCfg.KillMode != KillModeKillCgroup {
+		return nil
+	}
+
+	parent := p.closeCfg.CgroupParent
+	if parent == "" {
+		var err error
+		if parent, err = currentCgroup(); err != nil {
+			return err
+		}
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(parent, &st); err != nil {
+		return err
+	}
+	if st.Type != unix.CGROUP2_SUPER_MAGIC {
+		return fmt.Errorf("crosspty: %s is not a cgroup v2 directory", parent)
+	}
+
+	dir := filepath.Join(parent, fmt.Sprintf("crosspty-%d-%d", os.Getpid(), cgroupSeq.Add(1)))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return err
+	}
+	p.cgroup = dir
+
+	if cloneIntoCgroup() {
+		fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			p.removeCgroup()
+			return err
+		}
+		p.cgroupFD = fd
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = fd
+	}
+	return nil
+}
+
+// enterCgroup finishes setupCgroup once the subprocess started. Without
+// CLONE_INTO_CGROUP, the subprocess is moved into the cgroup now; processes
+// it forked in the meantime stay outside.
+func (p *ptyUnix) enterCgroup() error {
+	if p.cgroup == "" {
+		return nil
+	}
+	if p.cgroupFD != -1 {
+		unix.Close(p.cgroupFD)
+		p.cgroupFD = -1
+		return nil
+	}
+	return os.WriteFile(filepath.Join(p.cgroup, "cgroup.procs"), []byte(strconv.Itoa(p.cmd.Process.Pid)), 0)
+}
+
+func (p *ptyUnix) removeCgroup() {
+	if p.cgroupFD != -1 {
+		unix.Close(p.cgroupFD)
+		p.cgroupFD = -1
+	}
+	if p.cgroup == "" {
+		return
+	}
+	if p.cgroupPopulated() {
+		// Processes survived Close(), retrying is no use.
+		unix.Rmdir(p.cgroup)
+		return
+	}
+	// populated=0 may be reported shortly before the cgroup can be removed.
+	for range 50 {
+		if err := unix.Rmdir(p.cgroup); !errors.Is(err, unix.EBUSY) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// killCgroup runs a CloseKill step for KillModeKillCgroup. It returns a
+// function to call while waiting, if needed.
+func (p *ptyUnix) killCgroup(sr *CloseStepReport) func() {
+	sr.Signal = syscall.SIGKILL
+	if err := os.WriteFile(filepath.Join(p.cgroup, "cgroup.kill"), []byte("1"), 0); err != nil {
+		// cgroup.kill needs Linux 5.14. Keep killing to catch forks.
+		kill := func() { p.signalCgroup(syscall.SIGKILL) }
+		sr.Delivered = p.signalCgroup(syscall.SIGKILL) != 0
+		return kill
+	}
+	sr.Delivered = true
+	return nil
+}
+
+func (p *ptyUnix) cgroupPopulated() bool {
+	data, err := os.ReadFile(filepath.Join(p.cgroup, "cgroup.events"))
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(data, []byte("populated 1"))
+}
+
+// signalCgroup returns the number of processes signal was delivered to.
+func (p *ptyUnix) signalCgroup(signal syscall.Signal) (delivered int) {
+	data, err := os.ReadFile(filepath.Join(p.cgroup, "cgroup.procs"))
+	if err != nil {
+		return 0
+	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if pid, err := strconv.Atoi(line); err == nil && syscall.Kill(pid, signal) == nil {
+			delivered++
+		}
+	}
+	return delivered
+}
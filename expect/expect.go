@@ -0,0 +1,243 @@
+// Package expect scripts interactive programs running on a crosspty.Pty.
+//
+//	p, _ := crosspty.Start(crosspty.CommandConfig{Argv: []string{"python3", "-i"}})
+//	defer p.Close()
+//	e := expect.New(p, expect.Config{StripANSI: true})
+//	e.Expect(">>> ", 5*time.Second)
+//	e.SendLine("1+1")
+//	m, err := e.Expect(regexp.MustCompile(`(\d+)\r?\n`), 5*time.Second)
+//	// m.Groups[1] == "2"
+//
+// Patterns are matched against the output that has not been consumed by a
+// previous match yet. Like any expect implementation, a regexp may match a
+// prefix of output that is still arriving (e.g. `\d+` against "12" of
+// "123"); anchor patterns on something that follows.
+package expect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Kodecable/crosspty/internal/ansi"
+)
+
+var (
+	ErrTimeout    = errors.New("expect: timeout")
+	ErrBadPattern = errors.New("expect: pattern must be a string or *regexp.Regexp")
+)
+
+// Error is returned when no pattern matched. It wraps ErrTimeout, io.EOF
+// (the process exited and no more output can be read) or the read error.
+type Error struct {
+	Err error
+	// The unmatched output.
+	Buffer string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v (unmatched output: %q)", e.Err, e.Buffer)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+type Match struct {
+	// Index of the matched pattern in ExpectAny. Always 0 for Expect.
+	Index int
+	// Output consumed before the match.
+	Before string
+	// The matched text.
+	Text string
+	// Groups[0] is Text, followed by the captured groups of a regexp.
+	// Groups that did not participate in the match are empty.
+	Groups []string
+}
+
+// Consumed returns all output consumed by this match.
+func (m *Match) Consumed() string {
+	return m.Before + m.Text
+}
+
+type Config struct {
+	// Remove ANSI escape sequences from the output before matching.
+	StripANSI bool
+
+	// The oldest unmatched output is discarded when more than MaxBuffer
+	// bytes are buffered.
+	// default: 1 MiB
+	MaxBuffer int
+
+	// Appended by SendLine.
+	// default: "\r", or "\r\n" on Windows
+	LineEnding string
+}
+
+// Expecter reads output in the background until rw returns an error, for a
+// Pty that is until it returns io.EOF or is closed.
+//
+// Send and SendLine are thread-safe. Expect and ExpectAny MUST NOT be called
+// concurrently.
+type Expecter struct {
+	w   io.Writer
+	cfg Config
+
+	mu      sync.Mutex
+	buf     []byte
+	readErr error
+	notify  chan struct{}
+}
+
+// New starts reading from rw, which is usually a crosspty.Pty.
+func New(rw io.ReadWriter, cfg Config) *Expecter {
+	if cfg.MaxBuffer <= 0 {
+		cfg.MaxBuffer = 1 << 20
+	}
+	if cfg.LineEnding == "" {
+		cfg.LineEnding = "\r"
+		if runtime.GOOS == "windows" {
+			cfg.LineEnding = "\r\n"
+		}
+	}
+
+	e := &Expecter{
+		w:      rw,
+		cfg:    cfg,
+		notify: make(chan struct{}, 1),
+	}
+
+	var r io.Reader = rw
+	if cfg.StripANSI {
+		r = ansi.NewStripper(rw)
+	}
+	go e.reader(r)
+	return e
+}
+
+func (e *Expecter) reader(r io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+
+		e.mu.Lock()
+		e.buf = append(e.buf, buf[:n]...)
+		if over := len(e.buf) - e.cfg.MaxBuffer; over > 0 {
+			e.buf = append(e.buf[:0], e.buf[over:]...)
+		}
+		if err != nil {
+			e.readErr = err
+		}
+		e.mu.Unlock()
+
+		select {
+		case e.notify <- struct{}{}:
+		default:
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Buffer returns the output that has not been consumed yet.
+func (e *Expecter) Buffer() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return string(e.buf)
+}
+
+func (e *Expecter) Send(s string) error {
+	_, err := io.WriteString(e.w, s)
+	return err
+}
+
+// SendLine sends s followed by Config.LineEnding.
+func (e *Expecter) SendLine(s string) error {
+	return e.Send(s + e.cfg.LineEnding)
+}
+
+// Expect waits until pattern matches the output. pattern is a string
+// (matched literally) or a *regexp.Regexp. A timeout <= 0 means no timeout.
+func (e *Expecter) Expect(pattern any, timeout time.Duration) (*Match, error) {
+	return e.ExpectAny(timeout, pattern)
+}
+
+// ExpectAny waits until one of the patterns matches the output. If more
+// than one matches, the match that starts first wins, then the pattern that
+// comes first.
+func (e *Expecter) ExpectAny(timeout time.Duration, patterns ...any) (*Match, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		switch p := p.(type) {
+		case string:
+			res[i] = regexp.MustCompile(regexp.QuoteMeta(p))
+		case *regexp.Regexp:
+			res[i] = p
+		default:
+			return nil, ErrBadPattern
+		}
+	}
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	for {
+		e.mu.Lock()
+		if m := e.match(res); m != nil {
+			e.mu.Unlock()
+			return m, nil
+		}
+		if e.readErr != nil {
+			err := &Error{Err: e.readErr, Buffer: string(e.buf)}
+			e.mu.Unlock()
+			return nil, err
+		}
+		e.mu.Unlock()
+
+		select {
+		case <-e.notify:
+		case <-timer:
+			return nil, &Error{Err: ErrTimeout, Buffer: e.Buffer()}
+		}
+	}
+}
+
+// match must be called with mu held.
+func (e *Expecter) match(res []*regexp.Regexp) *Match {
+	best, bestLoc := -1, []int(nil)
+	for i, re := range res {
+		loc := re.FindSubmatchIndex(e.buf)
+		if loc != nil && (bestLoc == nil || loc[0] < bestLoc[0]) {
+			best, bestLoc = i, loc
+		}
+	}
+	if bestLoc == nil {
+		return nil
+	}
+
+	m := &Match{
+		Index:  best,
+		Before: string(e.buf[:bestLoc[0]]),
+		Text:   string(e.buf[bestLoc[0]:bestLoc[1]]),
+	}
+	for g := 0; g < len(bestLoc); g += 2 {
+		if bestLoc[g] < 0 {
+			m.Groups = append(m.Groups, "")
+			continue
+		}
+		m.Groups = append(m.Groups, string(e.buf[bestLoc[g]:bestLoc[g+1]]))
+	}
+
+	e.buf = append(e.buf[:0], e.buf[bestLoc[1]:]...)
+	return m
+}
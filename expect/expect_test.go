@@ -0,0 +1,182 @@
+package expect_test
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/expect"
+)
+
+// fakePty is the reading end of a pipe plus a record of what was written.
+type fakePty struct {
+	io.Reader
+	written strings.Builder
+}
+
+func (f *fakePty) Write(d []byte) (int, error) {
+	return f.written.Write(d)
+}
+
+func newFake() (*fakePty, *io.PipeWriter) {
+	r, w := io.Pipe()
+	return &fakePty{Reader: r}, w
+}
+
+func TestExpectString(t *testing.T) {
+	f, w := newFake()
+	e := expect.New(f, expect.Config{})
+	go w.Write([]byte("login: "))
+
+	m, err := e.Expect("login:", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Text != "login:" || m.Before != "" {
+		t.Fatalf("unexpected match: %+v", m)
+	}
+	if e.Buffer() != " " {
+		t.Fatalf("expected unmatched output to stay buffered, got %q", e.Buffer())
+	}
+}
+
+func TestExpectRegexpGroups(t *testing.T) {
+	f, w := newFake()
+	e := expect.New(f, expect.Config{})
+	go func() {
+		w.Write([]byte("result: 4"))
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("2\r\n"))
+	}()
+
+	m, err := e.Expect(regexp.MustCompile(`result: (\d+)\r\n`), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Groups) != 2 || m.Groups[1] != "42" {
+		t.Fatalf("unexpected groups: %q", m.Groups)
+	}
+	if m.Consumed() != "result: 42\r\n" {
+		t.Fatalf("unexpected consumed text: %q", m.Consumed())
+	}
+}
+
+func TestExpectAny(t *testing.T) {
+	f, w := newFake()
+	e := expect.New(f, expect.Config{})
+	go w.Write([]byte("Are you sure (yes/no)? Password: "))
+
+	m, err := e.ExpectAny(time.Second, "Password:", regexp.MustCompile(`\(yes/no\)\?`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Index != 1 || m.Before != "Are you sure " {
+		t.Fatalf("expected the earliest match to win, got %+v", m)
+	}
+
+	m, err = e.ExpectAny(time.Second, "Password:", regexp.MustCompile(`\(yes/no\)\?`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Index != 0 {
+		t.Fatalf("expected the password prompt, got %+v", m)
+	}
+}
+
+func TestExpectTimeout(t *testing.T) {
+	f, w := newFake()
+	defer w.Close()
+	e := expect.New(f, expect.Config{})
+	go w.Write([]byte("partial"))
+
+	_, err := e.Expect("never", 100*time.Millisecond)
+	var expErr *expect.Error
+	if !errors.Is(err, expect.ErrTimeout) || !errors.As(err, &expErr) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if expErr.Buffer != "partial" {
+		t.Fatalf("expected unmatched buffer in error, got %q", expErr.Buffer)
+	}
+}
+
+func TestExpectEOF(t *testing.T) {
+	f, w := newFake()
+	e := expect.New(f, expect.Config{})
+	go func() {
+		w.Write([]byte("bye"))
+		w.Close()
+	}()
+
+	_, err := e.Expect("never", time.Second)
+	var expErr *expect.Error
+	if !errors.Is(err, io.EOF) || !errors.As(err, &expErr) || expErr.Buffer != "bye" {
+		t.Fatalf("expected io.EOF with buffer, got %v", err)
+	}
+}
+
+func TestExpectStripANSI(t *testing.T) {
+	f, w := newFake()
+	e := expect.New(f, expect.Config{StripANSI: true})
+	go w.Write([]byte("\x1b[1;32mready\x1b[0m> "))
+
+	if _, err := e.Expect("ready> ", time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestExpectBadPattern(t *testing.T) {
+	f, _ := newFake()
+	e := expect.New(f, expect.Config{})
+	if _, err := e.Expect(42, time.Second); !errors.Is(err, expect.ErrBadPattern) {
+		t.Fatalf("expected ErrBadPattern, got %v", err)
+	}
+}
+
+func TestSendLine(t *testing.T) {
+	f, _ := newFake()
+	e := expect.New(f, expect.Config{LineEnding: "\n"})
+	if err := e.SendLine("hello"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.written.String() != "hello\n" {
+		t.Fatalf("unexpected written data: %q", f.written.String())
+	}
+}
+
+func TestExpectPty(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skip sh based test in windows")
+	}
+
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", "printf 'name? '; read n; echo \"hello, $n!\"; sleep 1"},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	e := expect.New(p, expect.Config{StripANSI: true})
+	if _, err := e.Expect("name? ", 5*time.Second); err != nil {
+		t.Fatalf("unable to expect prompt: %v", err)
+	}
+	if err := e.SendLine("crosspty"); err != nil {
+		t.Fatalf("unable to send line: %v", err)
+	}
+	m, err := e.Expect(regexp.MustCompile(`hello, (\w+)!`), 5*time.Second)
+	if err != nil {
+		t.Fatalf("unable to expect greeting: %v", err)
+	}
+	if m.Groups[1] != "crosspty" {
+		t.Fatalf("unexpected greeting: %q", m.Text)
+	}
+
+	if _, err := e.Expect("never", 5*time.Second); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF after process exit, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build linux
+
+package crosspty
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ForegroundProcess describes the process in the foreground of a terminal.
+type ForegroundProcess struct {
+	// The foreground process group.
+	Pgid int
+
+	// Whether the foreground process group is the one of the direct
+	// subprocess. For a shell, this means it is waiting at the prompt (or
+	// runs a job without job control), rather than running a job.
+	IsSubProcess bool
+
+	// The process group leader, or the lowest PID in the group if the
+	// leader has exited.
+	Pid int
+
+	// The command name, as in /proc/<pid>/comm. Possibly truncated to 15
+	// bytes.
+	Name string
+
+	// The command line. nil for zombies.
+	Argv []string
+
+	// The working directory. Empty if it can not be read, e.g. when the
+	// process belongs to another user.
+	Dir string
+}
+
+func (p *ptyUnix) ForegroundProcess() (ForegroundProcess, error) {
+	pgid, err := p.ForegroundPgid()
+	if err != nil {
+		return ForegroundProcess{}, err
+	}
+
+	fp := ForegroundProcess{
+		Pgid:         pgid,
+		IsSubProcess: pgid == p.Pid(),
+		Pid:          pgid,
+	}
+	if st, ok := readProcStat(pgid); !ok || st.pgrp != pgid {
+		if fp.Pid = findInProcessGroup(pgid); fp.Pid == 0 {
+			return fp, errors.New("crosspty: no process in foreground process group")
+		}
+	}
+
+	dir := "/proc/" + strconv.Itoa(fp.Pid)
+	comm, err := os.ReadFile(dir + "/comm")
+	if err != nil {
+		return fp, err
+	}
+	fp.Name = strings.TrimSuffix(string(comm), "\n")
+	if cmdline, err := os.ReadFile(dir + "/cmdline"); err == nil && len(cmdline) != 0 {
+		fp.Argv = strings.Split(string(bytes.TrimSuffix(cmdline, []byte{0})), "\x00")
+	}
+	fp.Dir, _ = os.Readlink(dir + "/cwd")
+	return fp, nil
+}
+
+// findInProcessGroup returns the lowest PID in the process group, or 0.
+func findInProcessGroup(pgid int) int {
+	pids, err := listPIDs()
+	if err != nil {
+		return 0
+	}
+	found := 0
+	for _, pid := range pids {
+		if found != 0 && pid > found {
+			continue
+		}
+		if st, ok := readProcStat(pid); ok && st.pgrp == pgid {
+			found = pid
+		}
+	}
+	return found
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package crosspty
+
+import "golang.org/x/sys/unix"
+
+func (p *ptyUnix) ForegroundPgid() (pgid int, err error) {
+	err = p.control(func(fd int) error {
+		pgid, err = unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+		return err
+	})
+	return
+}
@@ -4,6 +4,8 @@ go 1.24.0
 
 require (
 	github.com/creack/pty v1.1.24
+	golang.org/x/crypto v0.48.0
+	golang.org/x/net v0.50.0
 	golang.org/x/sys v0.41.0
 	golang.org/x/term v0.40.0
 )
@@ -0,0 +1,20 @@
+// Package procsignal delivers signals by name to the process of a Pty, for
+// front-ends whose protocols carry signal names (SSH, WebSocket control
+// messages).
+package procsignal
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUnknownSignal = errors.New("unknown signal")
+	ErrNotSupported  = errors.New("signals not supported")
+)
+
+// normalize returns the signal name without the "SIG" prefix, upper case.
+func normalize(name string) string {
+	name = strings.ToUpper(name)
+	return strings.TrimPrefix(name, "SIG")
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package procsignal
+
+import (
+	"strings"
+	"syscall"
+
+	"github.com/Kodecable/crosspty"
+
+	"golang.org/x/sys/unix"
+)
+
+// Send delivers the signal named name ("INT" or "SIGINT") to the process of
+// p. On Linux, the pidfd is used when available. Returns crosspty.ErrClosed
+// once the process exited or p was closed, and ErrNotSupported if p is not a
+// crosspty.PtyUnix.
+func Send(p crosspty.Pty, name string) error {
+	sig := unix.SignalNum("SIG" + normalize(name))
+	if sig == 0 {
+		return ErrUnknownSignal
+	}
+	pu, ok := p.(crosspty.PtyUnix)
+	if !ok {
+		// e.g. a record.Player, which has no process
+		return ErrNotSupported
+	}
+	return pu.Signal(sig)
+}
+
+// Name returns the name of sig without the "SIG" prefix, e.g. "INT", or ""
+// if unknown.
+func Name(sig syscall.Signal) string {
+	return strings.TrimPrefix(unix.SignalName(sig), "SIG")
+}
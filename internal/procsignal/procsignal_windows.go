@@ -0,0 +1,27 @@
+package procsignal
+
+import (
+	"syscall"
+
+	"github.com/Kodecable/crosspty"
+)
+
+// Send delivers the signal named name ("INT" or "SIGINT") to the process of
+// p. Windows has no signals: INT is sent as Ctrl+C input, KILL, TERM and HUP
+// close p. Other signals return ErrUnknownSignal.
+func Send(p crosspty.Pty, name string) error {
+	switch normalize(name) {
+	case "INT":
+		_, err := p.Write([]byte{0x03})
+		return err
+	case "KILL", "TERM", "HUP":
+		return p.Close()
+	default:
+		return ErrUnknownSignal
+	}
+}
+
+// Name returns "", Windows has no signals.
+func Name(sig syscall.Signal) string {
+	return ""
+}
@@ -0,0 +1,49 @@
+package crosspty
+
+import (
+	"log"
+	"runtime"
+	"runtime/debug"
+)
+
+// leakGuard is returned instead of the Pty for CloseConfig.CloseOnLeak.
+// It embeds the platform interface, so type assertions like p.(PtyLinux)
+// keep working.
+type leakGuard struct {
+	ptyImpl
+}
+
+// guardLeak wraps p for CloseConfig.CloseOnLeak. The cleanup only
+// references p, which the reaper goroutine keeps reachable anyway, so the
+// guard itself can become unreachable.
+func guardLeak(p ptyImpl, cfg CloseConfig) Pty {
+	if !cfg.CloseOnLeak {
+		return p
+	}
+
+	var stack []byte
+	if cfg.OnLeak != nil {
+		stack = debug.Stack()
+	}
+	g := &leakGuard{p}
+	runtime.AddCleanup(g, func(p ptyImpl) {
+		select {
+		case <-p.closeStarted():
+			return
+		default:
+		}
+		// Cleanups run on a single goroutine; Close() may block.
+		go func() {
+			if cfg.OnLeak != nil {
+				cfg.OnLeak(stack)
+			}
+			p.Close()
+		}()
+	}, p)
+	return g
+}
+
+// LogLeak is an OnLeak function that logs the stack with the log package.
+func LogLeak(stack []byte) {
+	log.Printf("crosspty: Pty was not closed, started at:\n%s", stack)
+}
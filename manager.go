@@ -0,0 +1,153 @@
+package crosspty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrManagerClosed is returned by Manager.Start after Manager.CloseAll.
+var ErrManagerClosed = errors.New("crosspty: manager closed")
+
+// Manager tracks the Ptys started through it, e.g. to close all of them when
+// a service shuts down. A Pty is tracked until its Close() finished.
+// The zero value is ready to use.
+// Thread-safe.
+type Manager struct {
+	mu     sync.Mutex
+	ptys   map[Pty]struct{}
+	closed bool
+}
+
+// closeNotifier is implemented by the Ptys of this package.
+type closeNotifier interface {
+	// closeStarted is closed when Close() is called for the first time.
+	closeStarted() <-chan any
+}
+
+// Start is like the package-level Start, and tracks the Pty. The returned
+// Pty is the one Start returns, so type assertions like p.(PtyLinux) work.
+func (m *Manager) Start(cc CommandConfig) (Pty, error) {
+	return m.StartContext(context.Background(), cc)
+}
+
+// StartContext is like the package-level StartContext, and tracks the Pty.
+func (m *Manager) StartContext(ctx context.Context, cc CommandConfig) (Pty, error) {
+	m.mu.Lock()
+	closed := m.closed
+	m.mu.Unlock()
+	if closed {
+		return nil, ErrManagerClosed
+	}
+
+	p, err := StartContext(ctx, cc)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		// CloseAll ran while the subprocess was starting.
+		m.mu.Unlock()
+		p.Close()
+		return nil, ErrManagerClosed
+	}
+	if m.ptys == nil {
+		m.ptys = make(map[Pty]struct{})
+	}
+	m.ptys[p] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		<-p.(closeNotifier).closeStarted()
+		p.Close() // waits for the running Close() to finish
+		m.mu.Lock()
+		delete(m.ptys, p)
+		m.mu.Unlock()
+	}()
+	return p, nil
+}
+
+// List returns the tracked Ptys, ordered by Pid().
+func (m *Manager) List() []Pty {
+	m.mu.Lock()
+	ptys := make([]Pty, 0, len(m.ptys))
+	for p := range m.ptys {
+		ptys = append(ptys, p)
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(ptys, func(a, b Pty) int { return a.Pid() - b.Pid() })
+	return ptys
+}
+
+// CloseAll closes the Manager and runs Close() of every tracked Pty
+// concurrently. It returns when all of them finished, or ctx is done; in
+// the latter case the close sequences keep running in the background, like
+// with CloseContext.
+// Once CloseAll is called, Start returns ErrManagerClosed.
+// If any Close() failed, the error is a *CloseAllError.
+func (m *Manager) CloseAll(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs map[int]error
+	)
+	for _, p := range m.List() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.CloseContext(ctx); err != nil {
+				mu.Lock()
+				if errs == nil {
+					errs = make(map[int]error)
+				}
+				errs[p.Pid()] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if errs != nil {
+		return &CloseAllError{Errs: errs}
+	}
+	return nil
+}
+
+// CloseAllError is returned by Manager.CloseAll when Close() failed for
+// some Ptys. errors.Is(err, ErrKillTimeout) reports whether any of them
+// timed out.
+type CloseAllError struct {
+	// The error of each failed Close(), by Pid().
+	Errs map[int]error
+}
+
+func (e *CloseAllError) Error() string {
+	pids := make([]int, 0, len(e.Errs))
+	for pid := range e.Errs {
+		pids = append(pids, pid)
+	}
+	slices.Sort(pids)
+
+	msgs := make([]string, len(pids))
+	for i, pid := range pids {
+		msgs[i] = fmt.Sprintf("%d: %v", pid, e.Errs[pid])
+	}
+	return "crosspty: unable to close ptys: " + strings.Join(msgs, "; ")
+}
+
+func (e *CloseAllError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		errs = append(errs, err)
+	}
+	return errs
+}
@@ -0,0 +1,67 @@
+package crosspty_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+)
+
+func TestManager(t *testing.T) {
+	var m crosspty.Manager
+	p1 := startPausedHelperWith(t, m.Start)
+	defer p1.Close()
+	p2 := startPausedHelperWith(t, m.Start)
+	defer p2.Close()
+
+	if n := len(m.List()); n != 2 {
+		t.Fatalf("expected 2 tracked ptys, got %d", n)
+	}
+
+	if err := p1.Close(); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		list := m.List()
+		if len(list) == 1 && list[0] == p2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected only the second pty to be tracked, got %d ptys", len(list))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.CloseAll(ctx); err != nil {
+		t.Fatalf("unable to close all ptys: %v", err)
+	}
+	if _, err := p2.WaitContext(ctx); err != nil {
+		t.Fatalf("expected subprocess to exit after CloseAll: %v", err)
+	}
+
+	_, err := m.Start(crosspty.CommandConfig{
+		Argv: []string{mustFindTestCommand(t)},
+	})
+	if !errors.Is(err, crosspty.ErrManagerClosed) {
+		t.Fatalf("expected ErrManagerClosed, got %v", err)
+	}
+}
+
+func TestCloseAllError(t *testing.T) {
+	err := error(&crosspty.CloseAllError{Errs: map[int]error{
+		2: context.DeadlineExceeded,
+		1: crosspty.ErrKillTimeout,
+	}})
+	if !errors.Is(err, crosspty.ErrKillTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected error to wrap both errors: %v", err)
+	}
+	want := "crosspty: unable to close ptys: 1: crosspty: kill process timeout; 2: context deadline exceeded"
+	if err.Error() != want {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+}
@@ -0,0 +1,21 @@
+//go:build freebsd
+
+package crosspty
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func startWithParentDeathSignal(cmd *exec.Cmd, sig syscall.Signal, start func() (*os.File, error)) (*os.File, error) {
+	if sig == 0 {
+		return start()
+	}
+	// procctl(PROC_PDEATHSIG_CTL) tracks the parent process, not a thread.
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Pdeathsig = sig
+	return start()
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+package crosspty
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+// The parent death signal of Linux is sent when the thread that started the
+// subprocess exits, not the process. Go ends the thread of a goroutine that
+// exits while locked to it (runtime.LockOSThread), which would kill the
+// subprocess early. Subprocesses with a parent death signal are therefore
+// started on a dedicated thread that never exits.
+var spawner = sync.OnceValue(func() chan<- func() {
+	ch := make(chan func())
+	go func() {
+		runtime.LockOSThread()
+		for fn := range ch {
+			fn()
+		}
+	}()
+	return ch
+})
+
+func startWithParentDeathSignal(cmd *exec.Cmd, sig syscall.Signal, start func() (*os.File, error)) (f *os.File, err error) {
+	if sig == 0 {
+		return start()
+	}
+	cmd.SysProcAttr.Pdeathsig = sig
+
+	done := make(chan struct{})
+	spawner() <- func() {
+		f, err = start()
+		close(done)
+	}
+	<-done
+	return
+}
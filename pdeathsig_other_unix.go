@@ -0,0 +1,15 @@
+//go:build unix && !linux && !freebsd
+
+package crosspty
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// No parent death signal here. When the current process dies, the kernel
+// closes the PTY master, which hangs up the terminal instead.
+func startWithParentDeathSignal(_ *exec.Cmd, _ syscall.Signal, start func() (*os.File, error)) (*os.File, error) {
+	return start()
+}
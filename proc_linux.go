@@ -0,0 +1,68 @@
+//go:build linux
+
+package crosspty
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procStat holds the fields of /proc/<pid>/stat used by this package.
+type procStat struct {
+	state   byte
+	ppid    int
+	pgrp    int
+	session int
+	ttyNr   uint64 // device number of the controlling terminal, 0 if none
+}
+
+func readProcStat(pid int) (st procStat, ok bool) {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return st, false
+	}
+	// The command name in parentheses may contain spaces and parentheses.
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return st, false
+	}
+	// ") state ppid pgrp session tty_nr ..."
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 5 || len(fields[0]) != 1 {
+		return st, false
+	}
+	st.state = fields[0][0]
+	if st.ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return st, false
+	}
+	if st.pgrp, err = strconv.Atoi(fields[2]); err != nil {
+		return st, false
+	}
+	if st.session, err = strconv.Atoi(fields[3]); err != nil {
+		return st, false
+	}
+	// tty_nr is printed as a signed int.
+	ttyNr, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return st, false
+	}
+	st.ttyNr = uint64(uint32(ttyNr))
+	return st, true
+}
+
+// listPIDs returns the PIDs in /proc.
+func listPIDs() ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, 0, len(entries))
+	for _, e := range entries {
+		if pid, err := strconv.Atoi(e.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
@@ -5,12 +5,29 @@ package crosspty
 import (
 	"strings"
 	"syscall"
+	"time"
 	"unicode/utf16"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
 )
 
+var procGetProcessMemoryInfo = kernel32.NewProc("K32GetProcessMemoryInfo")
+
+// see https://learn.microsoft.com/en-us/windows/win32/api/psapi/ns-psapi-process_memory_counters
+type processMemoryCounters struct {
+	Cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
 // sys must not be nil.
 func (p *ptyWin) createProcess(cc CommandConfig, sys *syscall.SysProcAttr) error {
 	pi := new(windows.ProcessInformation)
@@ -18,7 +35,7 @@ func (p *ptyWin) createProcess(cc CommandConfig, sys *syscall.SysProcAttr) error
 	// Need EXTENDED_STARTUPINFO_PRESENT as we're making use of the attribute list field.
 	flags := sys.CreationFlags | uint32(windows.CREATE_UNICODE_ENVIRONMENT) | windows.EXTENDED_STARTUPINFO_PRESENT
 	paused := false
-	if p.closeCfg.KillMode == KillModeKillGroupOnClose || p.closeCfg.KillMode == KillModeKillGroupOnSubProcessExit {
+	if p.closeCfg.KillMode != KillModeKillSubProcess {
 		flags = flags | windows.CREATE_SUSPENDED
 		paused = true
 	}
@@ -117,22 +134,48 @@ func (p *ptyWin) processWaiter() {
 
 	event, err := windows.WaitForSingleObject(windows.Handle(p.processHandle), windows.INFINITE)
 	if err != nil || event != windows.WAIT_OBJECT_0 {
-		p.exitCode = -1
+		p.status.Code = -1
 		return
 	}
+	p.status.Terminated = p.terminating.Load()
+	p.status.Killed = p.killing.Load()
+	p.usage = resourceUsageWin(p.processHandle)
 
 	var exitCode uint32
 	err = windows.GetExitCodeProcess(windows.Handle(p.processHandle), &exitCode)
 	if err != nil {
-		p.exitCode = -1
+		p.status.Code = -1
 	} else {
-		p.exitCode = int(exitCode)
+		p.status.Code = int(exitCode)
 		if p.closeCfg.KillMode == KillModeKillGroupOnSubProcessExit {
 			windows.TerminateJobObject(p.jobHandle, p.closeCfg.KillExitCode)
 		}
 	}
 }
 
+// Best effort, fields that can not be retrieved are left zero.
+func resourceUsageWin(process windows.Handle) (u ResourceUsage) {
+	var creation, exit, kernel, user windows.Filetime
+	if windows.GetProcessTimes(process, &creation, &exit, &kernel, &user) == nil {
+		// FILETIME durations are in 100-nanosecond intervals.
+		u.UserTime = time.Duration(int64(user.HighDateTime)<<32|int64(user.LowDateTime)) * 100
+		u.SystemTime = time.Duration(int64(kernel.HighDateTime)<<32|int64(kernel.LowDateTime)) * 100
+		u.StartTime = time.Unix(0, creation.Nanoseconds())
+		u.ExitTime = time.Unix(0, exit.Nanoseconds())
+		u.WallTime = u.ExitTime.Sub(u.StartTime)
+	}
+
+	if procGetProcessMemoryInfo.Find() == nil {
+		var pmc processMemoryCounters
+		pmc.Cb = uint32(unsafe.Sizeof(pmc))
+		r0, _, _ := procGetProcessMemoryInfo.Call(uintptr(process), uintptr(unsafe.Pointer(&pmc)), uintptr(pmc.Cb))
+		if r0 != 0 {
+			u.MaxRSS = int64(pmc.PeakWorkingSetSize)
+		}
+	}
+	return
+}
+
 func (p *ptyWin) createStartupInfoEx(sys *syscall.SysProcAttr) (*windows.StartupInfoEx, error) {
 	siEx := new(windows.StartupInfoEx)
 	siEx.Flags = windows.STARTF_USESTDHANDLES
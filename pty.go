@@ -25,12 +25,16 @@
 package crosspty
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -40,11 +44,85 @@ var (
 	ErrUnacceptableTimeout = errors.New("crosspty: unacceptable timeout or delay")
 	ErrKillTimeout         = errors.New("crosspty: kill process timeout")
 
+	// ErrKillModeNotSupported indicates that CloseConfig.KillMode is not
+	// supported on the current OS.
+	ErrKillModeNotSupported = errors.New("crosspty: kill mode not supported on this OS")
+
 	// ErrConPTYNotSupported indicates that the current Windows version does
 	// not support ConPTY.
 	ErrConPTYNotSupported = errors.New("crosspty: ConPTY not supported on this OS")
+
+	// ErrTermiosNotSupported indicates that a terminal attribute can not be
+	// set on the current OS, e.g. any attribute on Windows.
+	ErrTermiosNotSupported = errors.New("crosspty: terminal attribute not supported on this OS")
+
+	// ErrInvalidCloseStep indicates that CloseConfig.Steps contains a step
+	// that can not run, e.g. a CloseSignal step on Windows.
+	ErrInvalidCloseStep = errors.New("crosspty: invalid close step")
+
+	// ErrClosed is returned by the methods of a Pty that need the PTY after
+	// it was closed by Close(). errors.Is(ErrClosed, os.ErrClosed) is true.
+	ErrClosed = fmt.Errorf("crosspty: pty closed: %w", os.ErrClosed)
+)
+
+// closedErr turns the errors of using a closed file into ErrClosed.
+func closedErr(err error) error {
+	if errors.Is(err, os.ErrClosed) {
+		return ErrClosed
+	}
+	return err
+}
+
+// State is the lifecycle state of a Pty, see Pty.State.
+type State uint8
+
+const (
+	// The subprocess is running.
+	StateRunning State = iota
+
+	// The subprocess exited, and Close() was not called yet.
+	StateExited
+
+	// Close() is running.
+	StateClosing
+
+	// Close() finished. Methods that need the PTY return ErrClosed.
+	StateClosed
 )
 
+func (s State) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateExited:
+		return "exited"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("State(%d)", uint8(s))
+}
+
+// ptyState returns the State of a Pty from its channels. closed tells
+// whether Close() finished.
+func ptyState(exitch, closech <-chan any, closed bool) State {
+	if closed {
+		return StateClosed
+	}
+	select {
+	case <-closech:
+		return StateClosing
+	default:
+	}
+	select {
+	case <-exitch:
+		return StateExited
+	default:
+		return StateRunning
+	}
+}
+
 type TermSize struct {
 	Rows uint16 // Number of rows (in cells).
 	Cols uint16 // Number of columns (in cells).
@@ -52,6 +130,50 @@ type TermSize struct {
 	Y    uint16 // Height in pixels (optional, unix only).
 }
 
+// ExitStatus describes how the subprocess ended.
+type ExitStatus struct {
+	// Exit code of the subprocess (-1 means N/A, e.g., killed by signal).
+	Code int
+
+	// Unix only. The signal that terminated the subprocess, or 0 if it
+	// exited normally.
+	Signal syscall.Signal
+
+	// Unix only. Whether the subprocess dumped core.
+	CoreDumped bool
+
+	// Whether Close() had already run a close step (by default, closed the
+	// PTY, sent TermSignal or CTRL_CLOSE_EVENT) when the subprocess exited.
+	// On Unix, a subprocess that died from a signal while the step ran
+	// counts, too.
+	Terminated bool
+
+	// Whether Close() had already run the CloseKill step (sent KillSignal,
+	// or on Windows, forcibly terminated the process or Job Object) when the
+	// subprocess exited. On Unix, also only if the subprocess died from
+	// KillSignal (SIGKILL for KillModeKillCgroup).
+	//
+	// Terminated and Killed are sampled when the exit is collected, shortly
+	// after the subprocess exited. A subprocess that exited on its own just
+	// as Close() ran these steps may therefore be reported as Terminated, or
+	// on Windows, Killed, too.
+	Killed bool
+}
+
+// ResourceUsage reports the resources consumed by the subprocess.
+type ResourceUsage struct {
+	UserTime   time.Duration
+	SystemTime time.Duration
+
+	// Peak resident set size in bytes (peak working set size on Windows).
+	// It may include descendants that the subprocess waited for.
+	MaxRSS int64
+
+	StartTime time.Time
+	ExitTime  time.Time
+	WallTime  time.Duration // ExitTime - StartTime
+}
+
 type KillMode uint8
 
 const (
@@ -62,7 +184,8 @@ const (
 	// one of its descendants, CrossPTY can still kill that process group, but
 	// descendants that it kills may still remain as zombies until they are
 	// reaped by init or another subreaper. Container environments such as
-	// Docker should ensure that a proper init/subreaper is present.
+	// Docker should ensure that a proper init/subreaper is present, or call
+	// EnableChildSubreaper() on Linux.
 
 	// Kill the process group when the direct subprocess exits.
 	// On Windows, this starts the subprocess suspended, assigns it to a Job Object
@@ -88,18 +211,183 @@ const (
 	//   https://learn.microsoft.com/en-us/windows/console/creating-a-pseudoconsole-session
 	// On Linux < 5.3 or on other Unix systems, there is still a very small PID reuse race window.
 	KillModeKillSubProcess
+
+	// Defer cleanup to Close(), and apply it to the whole session rather
+	// than a process group: every process whose session ID is the PID of the
+	// subprocess, or whose controlling terminal is this PTY, found via /proc.
+	// This also covers jobs that an interactive shell put in their own
+	// process groups. Processes that called setsid() and dropped the PTY
+	// (e.g. daemons) are not covered.
+	// TermSignal and KillSignal are sent to all of them; TermSignalGroup is
+	// ignored. If some survive CloseTimeout, Close() returns a
+	// *KillSessionError listing them.
+	// Linux only; Start() returns ErrKillModeNotSupported on other Unix
+	// systems. On Windows, this is the same as KillModeKillGroupOnClose,
+	// since the Job Object already covers the whole process tree.
+	// There is a very small PID reuse race window between listing and
+	// signaling the processes.
+	KillModeKillSession
+
+	// Start the subprocess in its own cgroup v2 cgroup (see
+	// CloseConfig.CgroupParent), so that no descendant can escape, and
+	// defer cleanup to Close(). Close() sends TermSignal to every process in
+	// the cgroup (TermSignalGroup is ignored), kills them all with
+	// cgroup.kill (KillSignal is ignored, it is always SIGKILL), waits until
+	// the cgroup is empty and removes it.
+	// The subprocess is created in the cgroup with CLONE_INTO_CGROUP on
+	// Linux 5.7+. Otherwise it is moved there right after it starts, and
+	// processes it forks before the move are not covered. Without
+	// cgroup.kill (Linux < 5.14), the processes are killed one by one.
+	// Linux only; Start() returns ErrKillModeNotSupported on other Unix
+	// systems. On Windows, this is the same as KillModeKillGroupOnClose,
+	// since the Job Object already covers the whole process tree.
+	KillModeKillCgroup
+)
+
+type CloseAction uint8
+
+const (
+	// Send Signal to the subprocess, or to its process group if Group is
+	// true. With KillModeKillSession and KillModeKillCgroup, Group targets
+	// the whole session or cgroup instead.
+	// Unix only.
+	CloseSignal CloseAction = iota
+
+	// Write Data to the PTY, e.g. "exit\r" or Ctrl-D ("\x04"). Skipped
+	// after a CloseHangup step. On Unix, the write gives up after Wait if
+	// the subprocess does not read. Skipped on Darwin (including iOS),
+	// where PTY writes cannot be interrupted.
+	CloseWrite
+
+	// Close the PTY master. On Unix, the kernel sends SIGHUP to the
+	// subprocess and the foreground process group. On Windows, ConPTY sends
+	// CTRL_CLOSE_EVENT.
+	CloseHangup
+
+	// Force kill as the KillMode says: KillSignal to the subprocess or the
+	// process group (session, cgroup), TerminateProcess or
+	// TerminateJobObject on Windows. ExitStatus.Killed is reported once
+	// this step ran.
+	CloseKill
 )
 
+// CloseStep is one step of the close sequence, see CloseConfig.Steps.
+type CloseStep struct {
+	Action CloseAction
+
+	// For CloseSignal.
+	Signal syscall.Signal
+	Group  bool
+
+	// For CloseWrite.
+	Data []byte
+
+	// Time to wait for the subprocess to exit before the next step. The
+	// last step waits for the rest of CloseTimeout instead.
+	Wait time.Duration
+}
+
+// CloseReport describes how Close() ended the subprocess, see
+// Pty.CloseWithReport.
+type CloseReport struct {
+	// The close steps that ran, in order. Steps after the one that ended
+	// the subprocess did not run.
+	Steps []CloseStepReport
+
+	// Index in Steps of the step after which the subprocess had exited
+	// (and, in KillModeKillSession and KillModeKillCgroup, no other process
+	// was left), or -1 if this did not happen before CloseTimeout or a step
+	// failed.
+	EndedBy int
+
+	// Whether the subprocess had already exited when Close() started.
+	AlreadyExited bool
+
+	// The error of cleaning up processes other than the subprocess: the
+	// final kill of the process group (Job Object on Windows) in
+	// KillModeKillGroupOnClose, or the processes left in
+	// KillModeKillSession (*KillSessionError) and KillModeKillCgroup
+	// (wraps ErrKillTimeout and names the cgroup, which is not removed).
+	// nil if it succeeded or there was nothing to do.
+	CleanupErr error
+
+	// Time Close() took.
+	Elapsed time.Duration
+
+	// What Close() returned.
+	Err error
+}
+
+// CloseStepReport describes one step of the close sequence.
+type CloseStepReport struct {
+	Step CloseStep
+
+	// Time from the start of this step to the start of the next one, or the
+	// end of the close sequence.
+	Elapsed time.Duration
+
+	// Unix only. The signal this step sent, e.g. KillSignal for CloseKill,
+	// or 0 if none.
+	Signal syscall.Signal
+
+	// Unix only. Whether Signal was delivered, to at least one process for
+	// a session or cgroup.
+	Delivered bool
+
+	// Linux only. Whether Signal was sent through the pidfd rather than the
+	// PID, which rules out PID reuse.
+	PidFD bool
+
+	// The error of the step, e.g. EPERM when sending Signal. ESRCH is not
+	// returned by Close(), since the process already exited.
+	Err error
+}
+
+// KillSessionError is returned by Close() in KillModeKillSession when
+// processes of the session are still alive at the end of CloseTimeout, e.g.
+// because of missing permissions.
+type KillSessionError struct {
+	PIDs []int
+}
+
+func (e *KillSessionError) Error() string {
+	return fmt.Sprintf("crosspty: unable to kill session processes %v", e.PIDs)
+}
+
+// Unwrap returns ErrKillTimeout.
+func (e *KillSessionError) Unwrap() error {
+	return ErrKillTimeout
+}
+
 type CloseConfig struct {
 	// Total timeout for Close().
-	// Must be at least 1 second longer than KillDelay.
-	// default: 10s
+	// Must be at least 1 second longer than KillDelay, or the Wait of all
+	// Steps but the last one.
+	// default: 10s, or with Steps, their Wait plus 5s
 	CloseTimeout time.Duration
 
 	// Delay before attempting to force kill the process.
 	// default: 5s
 	KillDelay time.Duration
 
+	// default: nil
+	// The close sequence, run in order by Close() until the subprocess
+	// exited. If set, KillDelay, TermSignal and TermSignalGroup are
+	// ignored. For example, SIGINT, then SIGTERM, then SIGKILL:
+	//
+	//	Steps: []CloseStep{
+	//		{Action: CloseSignal, Signal: syscall.SIGINT, Wait: time.Second},
+	//		{Action: CloseSignal, Signal: syscall.SIGTERM, Wait: 3 * time.Second},
+	//		{Action: CloseKill},
+	//	}
+	//
+	// The default sequence is a CloseHangup step (or on Unix, a CloseSignal
+	// step with TermSignal and TermSignalGroup) with a Wait of KillDelay,
+	// then a CloseKill step. Whatever the steps, Close() closes the PTY at
+	// the end, and still cleans up the process group in
+	// KillModeKillGroupOnClose if the subprocess exited early.
+	Steps []CloseStep
+
 	// Unix only.
 	// default: SIGKILL
 	KillSignal syscall.Signal
@@ -121,6 +409,48 @@ type CloseConfig struct {
 
 	// default: KillModeKillGroupOnSubProcessExit
 	KillMode KillMode
+
+	// default: 0, disabled.
+	// Signal sent to the subprocess when the current process dies, even by
+	// SIGKILL or a crash, so that it does not keep running without owner.
+	//  - Linux: sets SysProcAttr.Pdeathsig. Linux sends it when the thread
+	//    that started the subprocess exits, so such subprocesses are started
+	//    on a dedicated OS thread that stays locked (runtime.LockOSThread)
+	//    and never exits. Only the direct subprocess gets the signal.
+	//  - FreeBSD: sets SysProcAttr.Pdeathsig (procctl(2)).
+	//  - Other Unix: not available. When the current process dies, the
+	//    kernel closes the PTY master and hangs up the terminal, which sends
+	//    SIGHUP to the subprocess and the foreground process group instead.
+	//    Processes that ignore SIGHUP survive.
+	//  - Windows: the Job Object gets JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE, so
+	//    every process in it is terminated (the signal value does not
+	//    matter). Ignored with KillModeKillSubProcess, which uses no Job
+	//    Object.
+	ParentDeathSignal syscall.Signal
+
+	// default: false
+	// Call Close() in the background once the Pty becomes unreachable
+	// without Close() having been called (runtime.AddCleanup), so that a
+	// forgotten Pty does not leak its FDs and processes. The garbage
+	// collector decides when this happens, if ever; it is a safety net, not
+	// a replacement for Close(). Keep the returned Pty itself, since it is a
+	// wrapper: the inner Pty stays reachable while the subprocess runs.
+	CloseOnLeak bool
+
+	// default: nil
+	// Used with CloseOnLeak. If set, Start records the stack trace of its
+	// caller, and OnLeak is called with it before a leaked Pty is closed.
+	// Recording the stack makes Start slower; meant for debugging, e.g.
+	// OnLeak: LogLeak.
+	OnLeak func(stack []byte)
+
+	// Linux only. Used by KillModeKillCgroup.
+	// default: the cgroup of the current process
+	// The cgroup v2 directory in which each Pty gets its own cgroup. It must
+	// be writable by the current user, e.g. a subtree delegated by systemd
+	// (Delegate=yes), and the current process must be allowed to move the
+	// subprocess there (see "Delegation Containment" in the cgroup v2 docs).
+	CgroupParent string
 }
 
 type CommandConfig struct {
@@ -158,6 +488,18 @@ type CommandConfig struct {
 	// default: 24x80
 	Size TermSize
 
+	// default: nil, the OS defaults
+	// Terminal attributes set before the subprocess starts. Attributes not
+	// covered by Termios keep the OS defaults. Start from DefaultTermios():
+	//
+	//	t := crosspty.DefaultTermios()
+	//	t.Echo = false
+	//	cc.Termios = &t
+	//
+	// Start() returns an error wrapping ErrTermiosNotSupported if an
+	// attribute is not supported on this OS. Windows supports none.
+	Termios *Termios
+
 	CloseConfig CloseConfig
 }
 
@@ -228,8 +570,12 @@ func ApplyEnvFallbackAndInject(Env []string, Fallback, Inject map[string]string)
 }
 
 // NormalizeCommandConfig is safe to call repeatedly with the same value.
+// It does not modify the Argv and EnvInject of cc_, so the same CommandConfig
+// can be used by concurrent Start calls.
 func NormalizeCommandConfig(cc_ CommandConfig) (cc CommandConfig, err error) {
 	cc = cc_
+	cc.Argv = slices.Clone(cc.Argv)
+	cc.EnvInject = maps.Clone(cc.EnvInject)
 	wd, err := os.Getwd()
 	if err != nil {
 		return cc, err
@@ -312,13 +658,37 @@ func NormalizeCommandConfig(cc_ CommandConfig) (cc CommandConfig, err error) {
 func normalizeCloseConfig(cc_ CloseConfig) (CloseConfig, error) {
 	cc := cc_
 
-	if cc.CloseTimeout == 0 && cc.KillDelay == 0 {
-		cc.CloseTimeout = 10 * time.Second
-		cc.KillDelay = 5 * time.Second
-	}
+	if len(cc.Steps) != 0 {
+		var wait time.Duration
+		for i, step := range cc.Steps {
+			if step.Action > CloseKill {
+				return cc, ErrInvalidCloseStep
+			}
+			if step.Action == CloseSignal && (runtime.GOOS == "windows" || step.Signal == 0) {
+				return cc, ErrInvalidCloseStep
+			}
+			if step.Wait < 0 {
+				return cc, ErrUnacceptableTimeout
+			}
+			if i != len(cc.Steps)-1 {
+				wait += step.Wait
+			}
+		}
+		if cc.CloseTimeout == 0 {
+			cc.CloseTimeout = wait + 5*time.Second
+		}
+		if cc.CloseTimeout-wait < 1*time.Second {
+			return cc, ErrUnacceptableTimeout
+		}
+	} else {
+		if cc.CloseTimeout == 0 && cc.KillDelay == 0 {
+			cc.CloseTimeout = 10 * time.Second
+			cc.KillDelay = 5 * time.Second
+		}
 
-	if cc.CloseTimeout-cc.KillDelay < 1*time.Second {
-		return cc, ErrUnacceptableTimeout
+		if cc.CloseTimeout-cc.KillDelay < 1*time.Second {
+			return cc, ErrUnacceptableTimeout
+		}
 	}
 
 	if cc.KillSignal == 0 {
@@ -328,6 +698,33 @@ func normalizeCloseConfig(cc_ CloseConfig) (CloseConfig, error) {
 	return cc, nil
 }
 
+// closeSteps returns Steps, or the default steps.
+func (cc CloseConfig) closeSteps() []CloseStep {
+	if len(cc.Steps) != 0 {
+		return cc.Steps
+	}
+	first := CloseStep{Action: CloseHangup, Wait: cc.KillDelay}
+	if cc.TermSignal != 0 && runtime.GOOS != "windows" {
+		// The session and cgroup modes always signal every process.
+		group := cc.TermSignalGroup || cc.KillMode == KillModeKillSession || cc.KillMode == KillModeKillCgroup
+		first = CloseStep{Action: CloseSignal, Signal: cc.TermSignal, Group: group, Wait: cc.KillDelay}
+	}
+	return []CloseStep{first, {Action: CloseKill}}
+}
+
+// stepWait returns how long Close() waits after steps[i]: its Wait, or for
+// the last step, what is left of CloseTimeout.
+func (cc CloseConfig) stepWait(steps []CloseStep, i int) time.Duration {
+	if i != len(steps)-1 {
+		return steps[i].Wait
+	}
+	wait := cc.CloseTimeout
+	for _, step := range steps[:i] {
+		wait -= step.Wait
+	}
+	return wait
+}
+
 // Pty represents a pseudo-terminal session.
 // It also manages the lifetime of a process attached to a pseudo-terminal.
 // Remember to handle escape sequences in the output.
@@ -343,7 +740,7 @@ type Pty interface {
 	// will not panic.
 	// After the process exits, Write usually does not block, but it MAY still
 	// block if too much data is written and the kernel buffer fills up.
-	// You MUST NOT call Write after Close().
+	// Returns ErrClosed once Close() closed the PTY.
 	// Thread-safe. It may be called concurrently with Read(). Concurrent Write
 	// calls behave the same way as concurrent writes to an os.File.
 	//
@@ -357,11 +754,25 @@ type Pty interface {
 	// descriptor and continue writing after the direct subprocess exits. Any
 	// remaining buffered output can still be read after the last slave
 	// descriptor closes.
-	// You MUST NOT call Read after Close().
+	// Returns ErrClosed once Close() closed the PTY; a pending Read is
+	// interrupted. On Darwin, a pending Read is not interrupted, it returns
+	// once the subprocess and other holders of the slave descriptor exited.
 	// Thread-safe. It may be called concurrently with Write(). Concurrent Read
 	// calls behave the same way as concurrent reads from an os.File.
 	Read(d []byte) (n int, err error)
 
+	// SetReadDeadline, SetWriteDeadline and SetDeadline behave like those of
+	// net.Conn: once the deadline passes, pending and future Read/Write
+	// calls return an error wrapping os.ErrDeadlineExceeded. A zero value
+	// means no deadline. The deadline does not affect the subprocess.
+	// On Windows and Darwin (including iOS), these return an error wrapping
+	// os.ErrNoDeadline.
+	// Return ErrClosed once Close() closed the PTY.
+	// Thread-safe.
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetDeadline(t time.Time) error
+
 	// Kill the subprocess and wait for it to exit (subject to timeout),
 	// freeing resources.
 	// Will attempt graceful termination first (SIGHUP, CTRL_CLOSE_EVENT, or
@@ -370,6 +781,12 @@ type Pty interface {
 	// Thread-safe. Can be called multiple times.
 	Close() error
 
+	// CloseWithReport is like Close, but also reports how the close
+	// sequence went. Later calls, and calls after Close(), wait for the
+	// close sequence and return the same report and error.
+	// Thread-safe.
+	CloseWithReport() (CloseReport, error)
+
 	// Wait for the child process to exit and return its exit code.
 	//  - If you do not read, the process may not exit (buffer full).
 	//  - A successful Close() will stop the wait.
@@ -379,6 +796,7 @@ type Pty interface {
 	//  - You do not have to call Wait() if you do not care about the exit code or process state.
 	//  - Thread-safe. Can be called multiple times from multiple goroutines.
 	//  - Returns the subprocess exit code (-1 means N/A, e.g., killed by signal).
+	//    Use WaitStatus() to tell signals and CrossPTY kills apart.
 	//
 	// For Windows:
 	//  - Wait() may also return -1 when the exit code could not be retrieved or the
@@ -389,19 +807,84 @@ type Pty interface {
 	//    it is hard sometimes to make sure the process was killed by CrossPTY.
 	Wait() int
 
+	// WaitStatus is like Wait, but returns the full ExitStatus.
+	// Thread-safe.
+	WaitStatus() ExitStatus
+
+	// Usage returns the resource usage of the subprocess. ok is false until
+	// the subprocess has exited (Wait() returned). After that, the result
+	// stays the same, including after Close().
+	// Thread-safe.
+	Usage() (usage ResourceUsage, ok bool)
+
+	// WaitContext is like Wait, but returns -1 and ctx.Err() if ctx is done
+	// before the subprocess exits. The subprocess is not affected.
+	// Thread-safe.
+	WaitContext(ctx context.Context) (int, error)
+
+	// CloseContext is like Close, but returns ctx.Err() if ctx is done
+	// before Close() finishes. The close sequence keeps running in the
+	// background, and later Close() calls wait for it to finish.
+	// Thread-safe.
+	CloseContext(ctx context.Context) error
+
 	// Thread-safe.
 	Pid() int
 
-	// Best-effort thread-safe. You MUST NOT call Resize after Close().
+	// State reports whether the subprocess is running and whether Close()
+	// was called. Every method may be called in any State; the PTY is
+	// closed during StateClosing, at the latest when it ends.
+	// Thread-safe.
+	State() State
+
+	// Best-effort thread-safe. Returns ErrClosed once Close() closed the PTY.
 	// On Windows, resizing causes the entire screen to be resent.
 	// On Unix, it will send SIGWINCH to subprocess.
 	Resize(sz TermSize) error
 }
 
 func Start(cc CommandConfig) (Pty, error) {
-	return start(cc)
+	return start(context.Background(), cc)
+}
 
-	// Experimental TODO: runtime.AddCleanup?
+// StartContext is like Start, but ties the Pty to ctx: once ctx is done,
+// Close() is called and runs the CloseConfig sequence. You still need to
+// call Close() yourself if ctx never ends.
+func StartContext(ctx context.Context, cc CommandConfig) (Pty, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return start(ctx, cc)
+}
+
+// watchContext calls closeFn once ctx is done. The watcher exits as soon as
+// closed is closed, so it never outlives Close().
+func watchContext(ctx context.Context, closed <-chan any, closeFn func() error) {
+	if ctx.Done() == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			closeFn()
+		case <-closed:
+		}
+	}()
+}
+
+// closeContext runs closeFn in the background and waits for it or ctx.
+func closeContext(ctx context.Context, closeFn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- closeFn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // A simple helper that runs the command once and collects all output.
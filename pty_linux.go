@@ -11,18 +11,35 @@ import (
 )
 
 type PtyLinux interface {
-	Pty
+	PtyUnix
 
 	// PidFD returns the Linux pidfd tracked by this package.
 	// The returned fd is owned by the Pty instance and remains valid until Close().
-	// It returns -1 when pidfd is not available.
+	// It returns -1 when pidfd is not available, and after Close().
 	PidFD() int
+
+	// ForegroundProcess resolves the foreground process group (see
+	// ForegroundPgid) to a process via /proc, e.g. for a tab title or to
+	// ask before closing a terminal with a running job.
+	ForegroundProcess() (ForegroundProcess, error)
+}
+
+// ptyImpl is the interface implemented by ptyUnix.
+type ptyImpl interface {
+	PtyLinux
+	closeNotifier
 }
 
 func (p *ptyUnix) PidFD() int {
+	p.pidFDMu.RLock()
+	defer p.pidFDMu.RUnlock()
 	return p.pidFD
 }
 
+func killModeSupported(KillMode) bool {
+	return true
+}
+
 func (p *ptyUnix) setSysProcAttr(cmd *exec.Cmd) {
 	p.pidFD = -1
 	if cmd.SysProcAttr == nil {
@@ -31,20 +48,22 @@ func (p *ptyUnix) setSysProcAttr(cmd *exec.Cmd) {
 	cmd.SysProcAttr.PidFD = &p.pidFD
 }
 
-func (p *ptyUnix) signal(group bool, signal syscall.Signal) (err error) {
+// sendSignal is signal, and also reports whether the pidfd was used.
+// Must be called with pidFDMu held for reading.
+func (p *ptyUnix) sendSignal(group bool, signal syscall.Signal) (pidFD bool, err error) {
 	const PIDFD_SIGNAL_PROCESS_GROUP = 4 // (since linux 6.9)
 
 	if p.pidFD == -1 {
-		return p.signalUnix(group, signal)
+		return false, p.signalUnix(group, signal)
 	} else {
 		if group {
 			err = unix.PidfdSendSignal(p.pidFD, signal, nil, PIDFD_SIGNAL_PROCESS_GROUP)
 			if errors.Is(err, syscall.EINVAL) {
-				return p.signalUnix(group, signal)
+				return false, p.signalUnix(group, signal)
 			}
-			return err
+			return true, err
 		} else {
-			return unix.PidfdSendSignal(p.pidFD, signal, nil, 0)
+			return true, unix.PidfdSendSignal(p.pidFD, signal, nil, 0)
 		}
 	}
 }
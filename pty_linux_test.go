@@ -3,7 +3,16 @@
 package crosspty_test
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -64,3 +73,280 @@ func TestKillModeKillGroupOnClose_PidfdOnly(t *testing.T) {
 		t.Fatalf("expected child %d to be killed after Close() in KillGroupOnClose mode", childPID)
 	}
 }
+
+func TestForegroundProcess_Linux(t *testing.T) {
+	dir := t.TempDir()
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		// set -m: run jobs in their own process group, like an interactive
+		// shell.
+		Argv: []string{"sh", "-c", "set -m; echo ready; read line; cd \"$1\"; sleep 100; true", "sh", dir},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	reader := bufio.NewReader(p)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+	go io.Copy(io.Discard, reader)
+
+	pl := p.(crosspty.PtyLinux)
+	fp, err := pl.ForegroundProcess()
+	if err != nil {
+		t.Fatalf("unable to get foreground process: %v", err)
+	}
+	if !fp.IsSubProcess || fp.Pid != p.Pid() || fp.Name != "sh" {
+		t.Fatalf("expected the shell in the foreground, got %+v", fp)
+	}
+
+	p.Write([]byte("\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for fp.IsSubProcess {
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not reach the foreground: %+v", fp)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if fp, err = pl.ForegroundProcess(); err != nil {
+			t.Fatalf("unable to get foreground process: %v", err)
+		}
+	}
+	if pgid, err := pl.ForegroundPgid(); err != nil || pgid != fp.Pgid {
+		t.Fatalf("ForegroundPgid() = %d, %v, want %d", pgid, err, fp.Pgid)
+	}
+	if fp.Name != "sleep" || !reflect.DeepEqual(fp.Argv, []string{"sleep", "100"}) || fp.Dir != dir {
+		t.Fatalf("unexpected foreground process: %+v", fp)
+	}
+}
+
+func TestKillModeKillSession_Linux(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		// The job runs in its own process group (set -m) and ignores the
+		// hangup, so only a session-wide kill reaches it.
+		Argv: []string{"sh", "-c", `set -m; sh -c 'trap "" HUP INT TERM; while :; do sleep 1; done' & echo job=$!; read line`},
+		CloseConfig: crosspty.CloseConfig{
+			CloseTimeout: 2 * time.Second,
+			KillDelay:    200 * time.Millisecond,
+			KillMode:     crosspty.KillModeKillSession,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+
+	var jobPID int
+	reader := bufio.NewReader(p)
+	for jobPID == 0 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read pty: %v", err)
+		}
+		fmt.Sscanf(line, "job=%d", &jobPID)
+	}
+	defer syscall.Kill(jobPID, syscall.SIGKILL)
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if !waitForProcessState(jobPID, false, time.Second) {
+		t.Fatalf("expected job %d to be killed with the session", jobPID)
+	}
+}
+
+func TestKillModeKillCgroup_Linux(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		// The job leaves the session and the process group, only the cgroup
+		// still contains it. It is started once Start() returned, so that it
+		// is covered without CLONE_INTO_CGROUP too.
+		Argv: []string{"sh", "-c", `read go; setsid sh -c 'echo job=$$; trap "" HUP INT TERM; while :; do sleep 1; done' & read line`},
+		CloseConfig: crosspty.CloseConfig{
+			CloseTimeout: 2 * time.Second,
+			KillDelay:    200 * time.Millisecond,
+			KillMode:     crosspty.KillModeKillCgroup,
+		},
+	})
+	if err != nil {
+		t.Skipf("no writable cgroup v2 delegation: %v", err)
+	}
+	p.Write([]byte("\n"))
+
+	var jobPID int
+	reader := bufio.NewReader(p)
+	for jobPID == 0 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read pty: %v", err)
+		}
+		fmt.Sscanf(line, "job=%d", &jobPID)
+	}
+	defer syscall.Kill(jobPID, syscall.SIGKILL)
+
+	cgroup, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", jobPID))
+	if err != nil || !strings.Contains(string(cgroup), "/crosspty-") {
+		t.Fatalf("expected the job in a crosspty cgroup, got %q, %v", cgroup, err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if !waitForProcessState(jobPID, false, time.Second) {
+		t.Fatalf("expected job %d to be killed with the cgroup", jobPID)
+	}
+	if st := p.WaitStatus(); !st.Terminated {
+		t.Fatalf("expected exit caused by Close(), got %+v", st)
+	}
+}
+
+func TestEnableChildSubreaper_Linux(t *testing.T) {
+	// The subreaper affects the whole process, so run in a child process.
+	if os.Getenv("GO_WANT_SUBREAPER_TEST") != "1" {
+		exe, err := os.Executable()
+		if err != nil {
+			t.Fatal("unable to locate exe:", err)
+		}
+		cmd := exec.Command(exe, "-test.run=^TestEnableChildSubreaper_Linux$", "-test.v")
+		cmd.Env = append(os.Environ(), "GO_WANT_SUBREAPER_TEST=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("subreaper test failed: %v\n%s", err, out)
+		}
+		return
+	}
+
+	if err := crosspty.EnableChildSubreaper(); err != nil {
+		t.Fatalf("unable to enable subreaper: %v", err)
+	}
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		// The orphan survives the hangup and the group kill when the shell
+		// exits, so that it is reparented to us.
+		Argv:        []string{"sh", "-c", `trap "" HUP; sleep 1 & echo orphan=$!; exit 7`},
+		CloseConfig: crosspty.CloseConfig{KillMode: crosspty.KillModeKillSubProcess},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	var orphan int
+	reader := bufio.NewReader(p)
+	for orphan == 0 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read pty: %v", err)
+		}
+		fmt.Sscanf(line, "orphan=%d", &orphan)
+	}
+	go io.Copy(io.Discard, reader)
+
+	// The exit status of the subprocess itself is not stolen.
+	if code := p.Wait(); code != 7 {
+		t.Fatalf("expected exit code 7, got %d", code)
+	}
+
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", orphan))
+	if err != nil {
+		t.Fatalf("orphan %d exited too early: %v", orphan, err)
+	}
+	if fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:])); fields[1] != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("expected orphan %d to be reparented to us, got ppid %s", orphan, fields[1])
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(fmt.Sprintf("/proc/%d", orphan)); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("orphan %d was not reaped", orphan)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestParentDeathSignal_Linux(t *testing.T) {
+	if os.Getenv("GO_WANT_PDEATHSIG_HELPER") == "1" {
+		// Start from a goroutine that exits while locked to its thread, which
+		// may end the thread. The subprocess must survive this.
+		ch := make(chan crosspty.Pty)
+		go func() {
+			runtime.LockOSThread()
+			p, err := crosspty.Start(crosspty.CommandConfig{
+				Argv:        []string{"sh", "-c", `trap "" HUP; echo pid=$$; while :; do sleep 1; done`},
+				CloseConfig: crosspty.CloseConfig{ParentDeathSignal: syscall.SIGKILL},
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "start: %v\n", err)
+				os.Exit(1)
+			}
+			ch <- p
+		}()
+		p := <-ch
+		io.Copy(os.Stdout, p)
+		os.Exit(0)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal("unable to locate exe:", err)
+	}
+	helper := exec.Command(exe, "-test.run=^TestParentDeathSignal_Linux$")
+	helper.Env = append(os.Environ(), "GO_WANT_PDEATHSIG_HELPER=1")
+	out, err := helper.StdoutPipe()
+	if err != nil {
+		t.Fatalf("unable to create pipe: %v", err)
+	}
+	if err := helper.Start(); err != nil {
+		t.Fatalf("unable to start helper: %v", err)
+	}
+	defer helper.Process.Kill()
+
+	var pid int
+	reader := bufio.NewReader(out)
+	for pid == 0 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read helper output: %v", err)
+		}
+		fmt.Sscanf(line, "pid=%d", &pid)
+	}
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	if waitForProcessState(pid, false, 300*time.Millisecond) {
+		t.Fatalf("subprocess %d died with the thread that started it", pid)
+	}
+
+	helper.Process.Kill()
+	helper.Wait()
+	if !waitForProcessState(pid, false, 2*time.Second) {
+		t.Fatalf("expected subprocess %d to die with its parent", pid)
+	}
+}
+
+func TestCloseWithReportPidFD_Linux(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", `trap "" HUP; echo ready; while :; do sleep 0.1; done`},
+		CloseConfig: crosspty.CloseConfig{
+			CloseTimeout: 2 * time.Second,
+			KillDelay:    100 * time.Millisecond,
+			KillMode:     crosspty.KillModeKillSubProcess,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	hasPidFD := p.(crosspty.PtyLinux).PidFD() != -1
+	if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+
+	rep, err := p.CloseWithReport()
+	if err != nil || rep.EndedBy != 1 {
+		t.Fatalf("expected the kill step to end the shell, got %+v, %v", rep, err)
+	}
+	if kill := rep.Steps[1]; kill.PidFD != hasPidFD {
+		t.Fatalf("expected PidFD %v, got %+v", hasPidFD, kill)
+	}
+	if fd := p.(crosspty.PtyLinux).PidFD(); fd != -1 {
+		t.Fatalf("expected no pidfd after Close, got %d", fd)
+	}
+}
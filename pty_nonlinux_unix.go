@@ -7,13 +7,60 @@ import (
 	"syscall"
 )
 
+// ptyImpl is the interface implemented by ptyUnix.
+type ptyImpl interface {
+	PtyUnix
+	closeNotifier
+}
+
 func (p *ptyUnix) setSysProcAttr(_ *exec.Cmd) {
 	p.pidFD = -1
 }
 
-func (p *ptyUnix) signal(group bool, signal syscall.Signal) error {
-	return p.signalUnix(group, signal)
+func (p *ptyUnix) sendSignal(group bool, signal syscall.Signal) (pidFD bool, err error) {
+	return false, p.signalUnix(group, signal)
 }
 
 func closePidFD(pidFd int) {
 }
+
+func killModeSupported(mode KillMode) bool {
+	return mode != KillModeKillSession && mode != KillModeKillCgroup
+}
+
+func (p *ptyUnix) setupCgroup(_ *exec.Cmd) error {
+	return nil
+}
+
+func (p *ptyUnix) enterCgroup() error {
+	return nil
+}
+
+func (p *ptyUnix) removeCgroup() {
+}
+
+func (p *ptyUnix) killCgroup(_ *CloseStepReport) func() {
+	return nil
+}
+
+func (p *ptyUnix) cgroupPopulated() bool {
+	return false
+}
+
+func (p *ptyUnix) signalCgroup(_ syscall.Signal) int {
+	return 0
+}
+
+func (p *ptyUnix) ttyDevice() uint64 {
+	return 0
+}
+
+func sessionProcesses(sid int, tty uint64) []int {
+	return nil
+}
+
+func registerSession(sid int) {
+}
+
+func releaseSession(sid int) {
+}
@@ -17,5 +17,5 @@ func (p *ptyUnix) Read(d []byte) (n int, err error) {
 		err = io.EOF
 	}
 
-	return n, err
+	return n, closedErr(err)
 }
@@ -3,6 +3,8 @@ package crosspty_test
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -258,6 +260,11 @@ func TestHelperProcess(t *testing.T) {
 		}
 		os.Exit(0)
 	}
+	if os.Getenv("GO_WANT_HELPER_PROCESS") == "6" {
+		writeHelperProtocolLine("READY", "")
+		testutils.Pause()
+		os.Exit(0)
+	}
 }
 
 func TestLongText(t *testing.T) {
@@ -586,6 +593,24 @@ func TestNormalizeCommandConfig_ExistingEnv(t *testing.T) {
 	}
 }
 
+func TestNormalizeCommandConfig_KeepsInput(t *testing.T) {
+	name := mustFindTestCommand(t)
+	argv := []string{name}
+	inject := map[string]string{"TERM": "xterm-256color"}
+
+	_, err := crosspty.NormalizeCommandConfig(crosspty.CommandConfig{Argv: argv, EnvInject: inject})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if argv[0] != name {
+		t.Errorf("expected Argv to be kept, got %q", argv[0])
+	}
+	if len(inject) != 1 {
+		t.Errorf("expected EnvInject to be kept, got %v", inject)
+	}
+}
+
 func TestWriteAfterProcessDead(t *testing.T) {
 	argv := []string{"uname", "-a"}
 	if runtime.GOOS == "windows" {
@@ -609,8 +634,246 @@ func TestWriteAfterProcessDead(t *testing.T) {
 	p.Wait()
 	a := p.Pid()
 	t.Log(a)
+	if st := p.State(); st != crosspty.StateExited {
+		t.Fatalf("expected state exited, got %v", st)
+	}
 
 	_, _ = p.Write([]byte("hello"))
 
 	// not panic, pass
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if st := p.State(); st != crosspty.StateClosed {
+		t.Fatalf("expected state closed, got %v", st)
+	}
+	if _, err := p.Write([]byte("hello")); !errors.Is(err, crosspty.ErrClosed) {
+		t.Errorf("expected ErrClosed from Write, got %v", err)
+	}
+	if _, err := p.Read(make([]byte, 16)); !errors.Is(err, crosspty.ErrClosed) {
+		t.Errorf("expected ErrClosed from Read, got %v", err)
+	}
+	if err := p.Resize(crosspty.TermSize{Rows: 24, Cols: 80}); !errors.Is(err, crosspty.ErrClosed) {
+		t.Errorf("expected ErrClosed from Resize, got %v", err)
+	}
+	if err := p.SetDeadline(time.Now()); !errors.Is(err, crosspty.ErrClosed) {
+		t.Errorf("expected ErrClosed from SetDeadline, got %v", err)
+	}
+	if !errors.Is(crosspty.ErrClosed, os.ErrClosed) {
+		t.Error("expected ErrClosed to wrap os.ErrClosed")
+	}
+}
+
+func TestStateRunningClosing(t *testing.T) {
+	p := startPausedHelper(t, context.Background())
+	if st := p.State(); st != crosspty.StateRunning {
+		t.Fatalf("expected state running, got %v", st)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- p.Close() }()
+	deadline := time.Now().Add(2 * time.Second)
+	st := p.State()
+	for st == crosspty.StateRunning {
+		if time.Now().After(deadline) {
+			t.Fatal("expected Close() to change the state")
+		}
+		time.Sleep(time.Millisecond)
+		st = p.State()
+	}
+	// Close() starts before the subprocess exits.
+	if st != crosspty.StateClosing && st != crosspty.StateClosed {
+		t.Fatalf("expected state closing, got %v", st)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if st := p.State(); st != crosspty.StateClosed {
+		t.Fatalf("expected state closed, got %v", st)
+	}
+}
+
+func startPausedHelper(t *testing.T, ctx context.Context) crosspty.Pty {
+	t.Helper()
+	return startPausedHelperWith(t, func(cc crosspty.CommandConfig) (crosspty.Pty, error) {
+		return crosspty.StartContext(ctx, cc)
+	})
+}
+
+// startPausedHelperWith is like startPausedHelper, and starts the helper
+// with start, e.g. Manager.Start.
+func startPausedHelperWith(t *testing.T, start func(crosspty.CommandConfig) (crosspty.Pty, error)) crosspty.Pty {
+	t.Helper()
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal("unable to locate exe:", err)
+	}
+
+	p, err := start(crosspty.CommandConfig{
+		Argv: []string{exe, "-test.run=TestHelperProcess"},
+		EnvInject: map[string]string{
+			"GO_WANT_HELPER_PROCESS": "6",
+		},
+		CloseConfig: crosspty.CloseConfig{
+			CloseTimeout: 3 * time.Second,
+			KillDelay:    500 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+
+	readHelperProtocolLine(t, bufio.NewReader(testutils.NewANSIStripper(p)), "READY")
+	return p
+}
+
+func TestStartContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := startPausedHelper(t, ctx)
+	defer p.Close()
+
+	cancel()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	if _, err := p.WaitContext(waitCtx); err != nil {
+		t.Fatalf("expected subprocess to exit after context cancel: %v", err)
+	}
+}
+
+func TestStartContextAlreadyDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := crosspty.StartContext(ctx, crosspty.CommandConfig{
+		Argv: []string{mustFindTestCommand(t)},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWaitContextTimeout(t *testing.T) {
+	p := startPausedHelper(t, context.Background())
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	code, err := p.WaitContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if code != -1 {
+		t.Fatalf("expected exit code -1, got %d", code)
+	}
+}
+
+func TestCloseContext(t *testing.T) {
+	p := startPausedHelper(t, context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.CloseContext(ctx); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if _, err := p.WaitContext(ctx); err != nil {
+		t.Fatalf("expected subprocess to exit after CloseContext: %v", err)
+	}
+}
+
+func TestPtyUsage(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal("unable to locate exe:", err)
+	}
+
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{exe, "-test.run=TestHelperProcess"},
+		EnvInject: map[string]string{
+			"GO_WANT_HELPER_PROCESS": "1",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+
+	_, _ = io.Copy(io.Discard, p)
+	p.Wait()
+
+	usage, ok := p.Usage()
+	if !ok {
+		t.Fatal("expected usage to be available after Wait()")
+	}
+	if usage.StartTime.IsZero() || usage.ExitTime.Before(usage.StartTime) {
+		t.Fatalf("bad start/exit time: %v, %v", usage.StartTime, usage.ExitTime)
+	}
+	if usage.WallTime != usage.ExitTime.Sub(usage.StartTime) {
+		t.Fatalf("bad wall time: %v", usage.WallTime)
+	}
+	if usage.UserTime+usage.SystemTime <= 0 {
+		t.Errorf("expected some CPU time, got %+v", usage)
+	}
+	if usage.MaxRSS <= 0 {
+		t.Errorf("expected max RSS to be reported, got %d", usage.MaxRSS)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if after, _ := p.Usage(); after != usage {
+		t.Fatalf("usage changed after Close(): %+v != %+v", after, usage)
+	}
+}
+
+func TestCloseStepsValidation(t *testing.T) {
+	start := func(cc crosspty.CloseConfig) error {
+		p, err := crosspty.Start(crosspty.CommandConfig{
+			Argv:        []string{mustFindTestCommand(t)},
+			CloseConfig: cc,
+		})
+		if err == nil {
+			p.Close()
+		}
+		return err
+	}
+
+	err := start(crosspty.CloseConfig{
+		CloseTimeout: 2 * time.Second,
+		Steps: []crosspty.CloseStep{
+			{Action: crosspty.CloseHangup, Wait: 1500 * time.Millisecond},
+			{Action: crosspty.CloseKill},
+		},
+	})
+	if !errors.Is(err, crosspty.ErrUnacceptableTimeout) {
+		t.Errorf("expected ErrUnacceptableTimeout for steps longer than CloseTimeout, got %v", err)
+	}
+
+	err = start(crosspty.CloseConfig{
+		Steps: []crosspty.CloseStep{{Action: crosspty.CloseHangup, Wait: -time.Second}},
+	})
+	if !errors.Is(err, crosspty.ErrUnacceptableTimeout) {
+		t.Errorf("expected ErrUnacceptableTimeout for a negative wait, got %v", err)
+	}
+
+	err = start(crosspty.CloseConfig{
+		Steps: []crosspty.CloseStep{{Action: crosspty.CloseSignal}},
+	})
+	if !errors.Is(err, crosspty.ErrInvalidCloseStep) {
+		t.Errorf("expected ErrInvalidCloseStep for a signal step without signal, got %v", err)
+	}
+
+	// CloseTimeout defaults to the waits plus 5s; KillDelay is ignored.
+	err = start(crosspty.CloseConfig{
+		KillDelay: time.Hour,
+		Steps: []crosspty.CloseStep{
+			{Action: crosspty.CloseHangup, Wait: time.Minute},
+			{Action: crosspty.CloseKill},
+		},
+	})
+	if err != nil {
+		t.Errorf("unexpected error for valid steps: %v", err)
+	}
 }
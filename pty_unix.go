@@ -3,30 +3,98 @@
 package crosspty
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	creackpty "github.com/creack/pty"
+	"golang.org/x/sys/unix"
 )
 
+// PtyUnix is implemented by the Pty returned by Start on Unix.
+//
+//	if pu, ok := p.(crosspty.PtyUnix); ok {
+//		t, _ := pu.Termios()
+//		t.Echo = false
+//		pu.SetTermios(t)
+//	}
+//
+// The terminal attributes belong to the terminal, so the subprocess sees the changes,
+// and it may change them again itself (e.g. a shell with line editing).
+// On some BSDs the attributes can not be set through the PTY master, and
+// SetTermios and SetRawTermios return an error.
+// They return ErrClosed once Close() closed the PTY.
+// Thread-safe.
+type PtyUnix interface {
+	Pty
+
+	// Termios returns the common terminal attributes.
+	Termios() (Termios, error)
+
+	// SetTermios sets the attributes in t, and keeps the attributes not
+	// covered by Termios unchanged. Changes take effect immediately.
+	SetTermios(t Termios) error
+
+	// RawTermios returns all terminal attributes.
+	RawTermios() (*unix.Termios, error)
+
+	// SetRawTermios sets all terminal attributes.
+	SetRawTermios(t *unix.Termios) error
+
+	// ForegroundPgid returns the foreground process group of the terminal,
+	// see tcgetpgrp(3). It equals Pid() when the direct subprocess is in the
+	// foreground, e.g. a shell without a running job.
+	ForegroundPgid() (int, error)
+
+	// Signal sends sig to the direct subprocess, through the pidfd on Linux.
+	// Unlike syscall.Kill(p.Pid(), sig), it returns ErrClosed instead of
+	// signaling once the subprocess exited or Close() finished, when the
+	// PID may have been reused.
+	Signal(sig syscall.Signal) error
+}
+
 type ptyUnix struct {
 	file *os.File
 	cmd  *exec.Cmd
 
-	pidFD int
+	pidFD   int
+	pidFDMu sync.RWMutex // held to close pidFD, and to signal through it
+
+	// Linux only, for KillModeKillCgroup.
+	cgroup   string
+	cgroupFD int
+
+	status  ExitStatus
+	usage   ResourceUsage
+	exitch  chan any
+	closech chan any
+	closer  sync.Once
+	closed  atomic.Bool // Close() finished
+
+	// Set right before file is closed.
+	fileClosed atomic.Bool
 
-	exitCode int
-	exitch   chan any
-	closer   sync.Once
+	// For KillModeKillSession, set by Close().
+	tty uint64
+
+	report CloseReport // set by Close()
 
 	closeCfg CloseConfig
+
+	// Set by Close() before the corresponding step, read by the reaper.
+	terminating atomic.Bool
+	killing     atomic.Bool
+	stepRan     atomic.Bool // set after the first step
 }
 
-func start(cc CommandConfig) (Pty, error) {
+func start(ctx context.Context, cc CommandConfig) (Pty, error) {
 	cc, err := NormalizeCommandConfig(cc)
 	if err != nil {
 		return nil, err
@@ -39,7 +107,7 @@ func start(cc CommandConfig) (Pty, error) {
 	cmd.Dir = cc.Dir
 	cmd.Env = cc.Env
 
-	return StartExecCmd(cmd, cc.Size, cc.CloseConfig)
+	return startExecCmd(ctx, cmd, cc.Size, cc.Termios, cc.CloseConfig)
 }
 
 // Unix only.
@@ -47,35 +115,186 @@ func start(cc CommandConfig) (Pty, error) {
 // On Linux, this function will overwrite cmd.SysProcAttr.PidFD.
 // Use this function only if you know exactly what you are doing.
 func StartExecCmd(cmd *exec.Cmd, sz TermSize, closeConfig CloseConfig) (Pty, error) {
+	return startExecCmd(context.Background(), cmd, sz, nil, closeConfig)
+}
+
+// Unix only.
+// Like StartExecCmd, but sets the terminal attributes t before cmd starts,
+// see CommandConfig.Termios.
+func StartExecCmdWithTermios(cmd *exec.Cmd, sz TermSize, t Termios, closeConfig CloseConfig) (Pty, error) {
+	return startExecCmd(context.Background(), cmd, sz, &t, closeConfig)
+}
+
+func startExecCmd(ctx context.Context, cmd *exec.Cmd, sz TermSize, t *Termios, closeConfig CloseConfig) (Pty, error) {
 	closeCfg, err := normalizeCloseConfig(closeConfig)
 	if err != nil {
 		return nil, err
 	}
+	if !killModeSupported(closeCfg.KillMode) {
+		return nil, ErrKillModeNotSupported
+	}
 
 	p := &ptyUnix{
 		cmd:      cmd,
 		exitch:   make(chan any),
+		closech:  make(chan any),
 		closeCfg: closeCfg,
 	}
 	p.setSysProcAttr(cmd)
+	if err := p.setupCgroup(cmd); err != nil {
+		return nil, err
+	}
 
-	of, err := creackpty.StartWithSize(cmd, creackptyWinsize(sz))
+	startTime := time.Now()
+	of, err := startWithParentDeathSignal(cmd, closeCfg.ParentDeathSignal, func() (*os.File, error) {
+		return startCmd(cmd, sz, t)
+	})
+	if err == nil {
+		if err = p.enterCgroup(); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			of.Close()
+		}
+	}
 	if err != nil {
+		p.removeCgroup()
+		closePidFD(p.pidFD)
 		return nil, err
 	}
-	p.file = of
+	p.file = newPollableFile(of)
+	registerSession(cmd.Process.Pid)
 
 	go func() {
-		// we collect exit code instead the error of Wait() here
+		// we collect exit status instead the error of Wait() here
 		cmd.Wait()
-		p.exitCode = cmd.ProcessState.ExitCode()
+		p.usage = resourceUsageUnix(cmd.ProcessState, startTime, time.Now())
+		p.status = exitStatusUnix(cmd.ProcessState)
+		// May be set for an exit that races with Close(), see ExitStatus.
+		p.status.Terminated = p.terminating.Load() &&
+			(p.status.Signal != 0 || p.stepRan.Load())
+		p.status.Killed = p.killing.Load() && p.status.Signal == p.killSignal()
 		if p.closeCfg.KillMode == KillModeKillGroupOnSubProcessExit {
 			p.signal(true, p.closeCfg.KillSignal)
 		}
 		close(p.exitch)
 	}()
 
-	return p, nil
+	watchContext(ctx, p.closech, p.Close)
+	return guardLeak(p, closeCfg), nil
+}
+
+// startCmd is creackpty.StartWithSize, but also sets the terminal
+// attributes before cmd starts.
+func startCmd(cmd *exec.Cmd, sz TermSize, t *Termios) (*os.File, error) {
+	if t == nil {
+		return creackpty.StartWithSize(cmd, creackptyWinsize(sz))
+	}
+
+	pty, tty, err := creackpty.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+
+	err = creackpty.Setsize(pty, creackptyWinsize(sz))
+	if err == nil {
+		var sc syscall.RawConn
+		if sc, err = tty.SyscallConn(); err == nil {
+			ctlErr := sc.Control(func(fd uintptr) {
+				err = setTermios(int(fd), *t)
+			})
+			if ctlErr != nil {
+				err = ctlErr
+			}
+		}
+	}
+	if err != nil {
+		pty.Close()
+		return nil, err
+	}
+
+	if cmd.Stdin == nil {
+		cmd.Stdin = tty
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = tty
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = tty
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+
+	if err := cmd.Start(); err != nil {
+		pty.Close()
+		return nil, err
+	}
+	return pty, nil
+}
+
+func exitStatusUnix(ps *os.ProcessState) ExitStatus {
+	st := ExitStatus{Code: ps.ExitCode()}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		st.Signal = ws.Signal()
+		st.CoreDumped = ws.CoreDump()
+	}
+	return st
+}
+
+func resourceUsageUnix(ps *os.ProcessState, startTime, exitTime time.Time) ResourceUsage {
+	u := ResourceUsage{
+		UserTime:   ps.UserTime(),
+		SystemTime: ps.SystemTime(),
+		StartTime:  startTime,
+		ExitTime:   exitTime,
+		WallTime:   exitTime.Sub(startTime),
+	}
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		u.MaxRSS = int64(ru.Maxrss)
+		// ru_maxrss is in bytes on Darwin and in kilobytes elsewhere.
+		if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+			u.MaxRSS *= 1024
+		}
+	}
+	return u
+}
+
+// signal may be called from the reaper concurrently with Close().
+func (p *ptyUnix) signal(group bool, signal syscall.Signal) error {
+	p.pidFDMu.RLock()
+	defer p.pidFDMu.RUnlock()
+	if p.closed.Load() {
+		return ErrClosed
+	}
+	_, err := p.sendSignal(group, signal)
+	return err
+}
+
+func (p *ptyUnix) Signal(sig syscall.Signal) error {
+	p.pidFDMu.RLock()
+	defer p.pidFDMu.RUnlock()
+	select {
+	case <-p.exitch:
+		return ErrClosed
+	default:
+	}
+	if p.closed.Load() {
+		return ErrClosed
+	}
+	_, err := p.sendSignal(false, sig)
+	return err
+}
+
+// markClosed closes the pidfd and marks p closed, after the close sequence.
+func (p *ptyUnix) markClosed() {
+	p.pidFDMu.Lock()
+	defer p.pidFDMu.Unlock()
+	closePidFD(p.pidFD)
+	p.pidFD = -1
+	p.closed.Store(true)
 }
 
 func (p *ptyUnix) signalUnix(group bool, signal syscall.Signal) error {
@@ -94,69 +313,347 @@ func (p *ptyUnix) signalUnix(group bool, signal syscall.Signal) error {
 
 func (p *ptyUnix) Close() (err error) {
 	p.closer.Do(func() {
-		defer closePidFD(p.pidFD)
-		if p.closeCfg.TermSignal == 0 {
-			p.file.Close() // trigger SIGHUP
-		} else {
-			defer p.file.Close()
-			p.signal(p.closeCfg.TermSignalGroup, p.closeCfg.TermSignal)
+		close(p.closech)
+		defer p.markClosed()
+		defer releaseSession(p.cmd.Process.Pid)
+		defer p.removeCgroup()
+		defer p.closeFile()
+		if p.closeCfg.KillMode == KillModeKillSession {
+			p.tty = p.ttyDevice() // while the FD is still open
 		}
 
-		select {
-		case <-time.After(p.closeCfg.KillDelay):
-			break
-		case <-p.exitch:
-			if p.closeCfg.KillMode != KillModeKillGroupOnClose {
-				return
-			}
-		}
+		start := time.Now()
+		err = p.runCloseSteps(&p.report)
+		p.report.Elapsed = time.Since(start)
+		p.report.Err = err
+	})
+	return
+}
 
-		err = p.signal(p.closeCfg.KillMode != KillModeKillSubProcess, p.closeCfg.KillSignal)
-		if err != nil {
-			if errors.Is(err, syscall.ESRCH) {
-				// It's dead, ok
-				err = nil
-				return
-			}
+func (p *ptyUnix) CloseWithReport() (CloseReport, error) {
+	p.Close() // waits for a running Close()
+	return p.report, p.report.Err
+}
+
+// runCloseSteps runs CloseConfig.Steps until the subprocess exited.
+func (p *ptyUnix) runCloseSteps(rep *CloseReport) error {
+	rep.EndedBy = -1
+	select {
+	case <-p.exitch:
+		rep.AlreadyExited = true
+	default:
+	}
+
+	steps := p.closeCfg.closeSteps()
+	var tick func()
+	var permErr error
+	for i, step := range steps {
+		stepStart := time.Now()
+		wait := p.closeCfg.stepWait(steps, i)
+		sr := CloseStepReport{Step: step}
+		p.terminating.Store(true)
+		switch step.Action {
+		case CloseSignal:
+			p.closeSignal(step.Group, step.Signal, &sr)
+		case CloseWrite:
+			p.closeWrite(step.Data, stepStart.Add(wait))
+			wait -= time.Since(stepStart)
+		case CloseHangup:
+			p.closeFile()
+		case CloseKill:
+			p.killing.Store(true)
+			tick = p.closeKill(&sr)
+		}
+		p.stepRan.Store(true)
+		if err := sr.Err; err != nil && !errors.Is(err, syscall.ESRCH) {
 			if !errors.Is(err, syscall.EPERM) {
-				return
+				sr.Elapsed = time.Since(stepStart)
+				rep.Steps = append(rep.Steps, sr)
+				return err
 			}
 			// EPERM? maybe the pid was recycled or a true EPERM
-			// If it's recycled, we will get exitch closed soon, so wait a sec
+			// If it's recycled, we will get exitch closed soon, so wait
+			permErr = err
 		}
 
-		select {
-		case <-time.After(p.closeCfg.CloseTimeout - p.closeCfg.KillDelay):
-			if errors.Is(err, syscall.EPERM) {
-				// Damm, it's true EPERM
-				// Maybe sudo or SELinux? Whatever, can't handle, tell user
-				return
-			}
-			err = ErrKillTimeout
+		ended := p.waitClose(wait, tick)
+		sr.Elapsed = time.Since(stepStart)
+		rep.Steps = append(rep.Steps, sr)
+		if ended {
+			rep.EndedBy = i
+			rep.CleanupErr = p.closeExited()
+			return rep.CleanupErr
+		}
+	}
+
+	switch p.closeCfg.KillMode {
+	case KillModeKillSession:
+		if pids := sessionProcesses(p.cmd.Process.Pid, p.tty); len(pids) != 0 {
+			rep.CleanupErr = &KillSessionError{PIDs: pids}
+			return rep.CleanupErr
+		}
+	case KillModeKillCgroup:
+		if p.cgroupPopulated() {
+			// removeCgroup cannot remove it either.
+			rep.CleanupErr = fmt.Errorf("%w: cgroup %s left behind", ErrKillTimeout, p.cgroup)
+		}
+	}
+	if permErr != nil {
+		// Damm, it's true EPERM
+		// Maybe sudo or SELinux? Whatever, can't handle, tell user
+		return permErr
+	}
+	return ErrKillTimeout
+}
+
+// closeSignal runs a CloseSignal step.
+func (p *ptyUnix) closeSignal(group bool, signal syscall.Signal, sr *CloseStepReport) {
+	sr.Signal = signal
+	if group {
+		switch p.closeCfg.KillMode {
+		case KillModeKillSession:
+			sr.Delivered = signalSession(p.cmd.Process.Pid, p.tty, signal) != 0
 			return
-		case <-p.exitch:
-			err = nil
+		case KillModeKillCgroup:
+			sr.Delivered = p.signalCgroup(signal) != 0
 			return
 		}
-	})
-	return
+	}
+	p.pidFDMu.RLock()
+	sr.PidFD, sr.Err = p.sendSignal(group, signal)
+	p.pidFDMu.RUnlock()
+	sr.Delivered = sr.Err == nil
+}
+
+// closeWrite runs a CloseWrite step. The write blocks if the subprocess does
+// not read, so it gives up at deadline. Without deadlines (Darwin), it would
+// block until the subprocess reads, so it is skipped.
+func (p *ptyUnix) closeWrite(d []byte, deadline time.Time) {
+	if p.fileClosed.Load() {
+		return
+	}
+	// No need to reset the deadline, Close() closes the file at the end.
+	if p.file.SetWriteDeadline(deadline) != nil {
+		return
+	}
+	p.file.Write(d)
+}
+
+// closeKill runs a CloseKill step. The returned function, if not nil, is
+// called while waiting afterwards.
+func (p *ptyUnix) closeKill(sr *CloseStepReport) (tick func()) {
+	switch p.closeCfg.KillMode {
+	case KillModeKillSession:
+		p.closeSignal(true, p.closeCfg.KillSignal, sr)
+		return nil
+	case KillModeKillCgroup:
+		return p.killCgroup(sr)
+	}
+	p.closeSignal(p.closeCfg.KillMode != KillModeKillSubProcess, p.closeCfg.KillSignal, sr)
+	return nil
+}
+
+// killSignal returns the signal the CloseKill step sends.
+func (p *ptyUnix) killSignal() syscall.Signal {
+	if p.closeCfg.KillMode == KillModeKillCgroup {
+		return syscall.SIGKILL // cgroup.kill
+	}
+	return p.closeCfg.KillSignal
+}
+
+// closeExited finishes Close() once the subprocess exited.
+func (p *ptyUnix) closeExited() error {
+	if p.closeCfg.KillMode != KillModeKillGroupOnClose {
+		return nil
+	}
+	err := p.signal(true, p.closeCfg.KillSignal)
+	if errors.Is(err, syscall.ESRCH) || errors.Is(err, syscall.EPERM) {
+		return nil
+	}
+	return err
+}
+
+// waitClose waits until the subprocess exited and, in KillModeKillSession
+// and KillModeKillCgroup, no other process is left. It reports whether
+// this happened before timeout. tick, if not nil, is called while waiting.
+func (p *ptyUnix) waitClose(timeout time.Duration, tick func()) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	exitch := p.exitch
+	for {
+		select {
+		case <-p.exitch:
+			if !p.closeLeftovers() {
+				return true
+			}
+			exitch = nil // poll the leftovers
+		default:
+		}
+		select {
+		case <-exitch:
+		case <-ticker.C:
+			if tick != nil {
+				tick()
+			}
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// closeLeftovers reports whether processes other than the subprocess are
+// left that Close() waits for.
+func (p *ptyUnix) closeLeftovers() bool {
+	switch p.closeCfg.KillMode {
+	case KillModeKillSession:
+		return len(sessionProcesses(p.cmd.Process.Pid, p.tty)) != 0
+	case KillModeKillCgroup:
+		return p.cgroupPopulated()
+	}
+	return false
+}
+
+// signalSession returns the number of processes signal was delivered to.
+// Failures show up as survivors in runCloseSteps.
+func signalSession(sid int, tty uint64, signal syscall.Signal) (delivered int) {
+	for _, pid := range sessionProcesses(sid, tty) {
+		if syscall.Kill(pid, signal) == nil {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+func (p *ptyUnix) closeStarted() <-chan any {
+	return p.closech
+}
+
+func (p *ptyUnix) CloseContext(ctx context.Context) error {
+	return closeContext(ctx, p.Close)
 }
 
 func (p *ptyUnix) Write(d []byte) (n int, err error) {
-	return p.file.Write(d)
+	n, err = p.file.Write(d)
+	return n, closedErr(err)
 }
 
 func (p *ptyUnix) Wait() int {
 	<-p.exitch
-	return p.exitCode
+	return p.status.Code
+}
+
+func (p *ptyUnix) WaitStatus() ExitStatus {
+	<-p.exitch
+	return p.status
+}
+
+func (p *ptyUnix) Usage() (ResourceUsage, bool) {
+	select {
+	case <-p.exitch:
+		return p.usage, true
+	default:
+		return ResourceUsage{}, false
+	}
+}
+
+func (p *ptyUnix) WaitContext(ctx context.Context) (int, error) {
+	select {
+	case <-p.exitch:
+		return p.status.Code, nil
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
 }
 
 func (p *ptyUnix) Pid() int {
 	return p.cmd.Process.Pid
 }
 
-func (p *ptyUnix) Resize(sz TermSize) error {
-	return creackpty.Setsize(p.file, creackptyWinsize(sz))
+func (p *ptyUnix) State() State {
+	return ptyState(p.exitch, p.closech, p.closed.Load())
+}
+
+func (p *ptyUnix) SetReadDeadline(t time.Time) error {
+	return p.deadlineErr(p.file.SetReadDeadline(t))
+}
+
+func (p *ptyUnix) SetWriteDeadline(t time.Time) error {
+	return p.deadlineErr(p.file.SetWriteDeadline(t))
+}
+
+func (p *ptyUnix) SetDeadline(t time.Time) error {
+	return p.deadlineErr(p.file.SetDeadline(t))
+}
+
+// deadlineErr returns ErrClosed for deadline errors of a closed file, which
+// os.File does not wrap in os.ErrClosed.
+func (p *ptyUnix) deadlineErr(err error) error {
+	if err != nil && p.fileClosed.Load() {
+		return ErrClosed
+	}
+	return err
+}
+
+func (p *ptyUnix) closeFile() {
+	if !p.fileClosed.Swap(true) {
+		p.file.Close()
+	}
+}
+
+// control runs fn with the PTY master FD without changing its blocking mode.
+func (p *ptyUnix) control(fn func(fd int) error) error {
+	sc, err := p.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	err = sc.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	})
+	if err != nil {
+		// Control only fails once the file was closed.
+		return ErrClosed
+	}
+	return fnErr
+}
+
+// creack/pty leaves the PTY master in blocking mode, which the Go runtime
+// poller can not use. Duplicate it into a non-blocking file so that
+// deadlines work and Close() can interrupt pending reads. Falls back to the
+// original file on failure.
+func newPollableFile(f *os.File) *os.File {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		// kqueue does not report PTY readiness reliably on Darwin.
+		return f
+	}
+
+	sc, err := f.SyscallConn()
+	if err != nil {
+		return f
+	}
+	var nfd int
+	var dupErr error
+	err = sc.Control(func(fd uintptr) {
+		// F_DUPFD_CLOEXEC is not available everywhere, do what os does.
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if nfd, dupErr = syscall.Dup(int(fd)); dupErr == nil {
+			syscall.CloseOnExec(nfd)
+		}
+	})
+	if err != nil || dupErr != nil {
+		return f
+	}
+	if err = unix.SetNonblock(nfd, true); err != nil {
+		unix.Close(nfd)
+		return f
+	}
+
+	nf := os.NewFile(uintptr(nfd), f.Name())
+	f.Close() // nf still holds the master
+	return nf
 }
 
 func creackptyWinsize(sz TermSize) *creackpty.Winsize {
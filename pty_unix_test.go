@@ -4,6 +4,7 @@ package crosspty_test
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -20,6 +21,8 @@ import (
 
 	"github.com/Kodecable/crosspty"
 	"github.com/Kodecable/crosspty/internal/testutils"
+
+	"golang.org/x/sys/unix"
 )
 
 func TestHelperProcessUnix(t *testing.T) {
@@ -355,3 +358,575 @@ func testTermSignalGroupUnix(t *testing.T, termSignalGroup bool, wantChildAlive
 		t.Fatalf("expected child %d to exit when TermSignalGroup is true", childPID)
 	}
 }
+
+func TestWaitStatusSignaled_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", "kill -TERM $$"},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	st := p.WaitStatus()
+	if st.Code != -1 || st.Signal != syscall.SIGTERM {
+		t.Fatalf("expected SIGTERM with code -1, got %+v", st)
+	}
+	if st.Terminated || st.Killed {
+		t.Fatalf("expected exit not caused by CrossPTY, got %+v", st)
+	}
+	if p.Wait() != st.Code {
+		t.Fatalf("Wait() = %d, want %d", p.Wait(), st.Code)
+	}
+}
+
+func TestSignal_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sleep", "100"},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	pu := p.(crosspty.PtyUnix)
+	if err := pu.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("unable to signal: %v", err)
+	}
+	if st := p.WaitStatus(); st.Signal != syscall.SIGTERM {
+		t.Fatalf("expected SIGTERM, got %+v", st)
+	}
+	if err := pu.Signal(syscall.SIGTERM); !errors.Is(err, crosspty.ErrClosed) {
+		t.Fatalf("expected ErrClosed after exit, got %v", err)
+	}
+}
+
+func TestWaitStatusExitCode_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", "exit 3"},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	st := p.WaitStatus()
+	if st != (crosspty.ExitStatus{Code: 3}) {
+		t.Fatalf("expected clean exit with code 3, got %+v", st)
+	}
+}
+
+func TestWaitStatusKilledByClose_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", "trap '' HUP INT TERM; echo ready; while :; do sleep 0.1; done"},
+		Env:  []string{},
+		CloseConfig: crosspty.CloseConfig{
+			CloseTimeout: 2 * time.Second,
+			KillDelay:    200 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+
+	if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+
+	st := p.WaitStatus()
+	if st.Signal != syscall.SIGKILL || !st.Terminated || !st.Killed {
+		t.Fatalf("expected SIGKILL initiated by Close(), got %+v", st)
+	}
+}
+
+func TestReadDeadline_Unix(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("deadlines are not supported on Darwin")
+	}
+
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", "echo ready; read line; echo got $line"},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	reader := bufio.NewReader(p)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+
+	if err := p.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatalf("unable to set read deadline: %v", err)
+	}
+	start := time.Now()
+	_, err = p.Read(make([]byte, 16))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected os.ErrDeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("read deadline took too long: %v", elapsed)
+	}
+
+	if err := p.SetDeadline(time.Time{}); err != nil {
+		t.Fatalf("unable to clear deadline: %v", err)
+	}
+	if _, err := p.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("unable to write pty: %v", err)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read pty after clearing deadline: %v", err)
+		}
+		if strings.HasPrefix(line, "got hello") {
+			break
+		}
+	}
+}
+
+func TestTermios_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", "echo ready; read line; stty -a; echo got $line"},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	reader := bufio.NewReader(p)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+
+	pu, ok := p.(crosspty.PtyUnix)
+	if !ok {
+		t.Fatalf("expected Pty to implement PtyUnix")
+	}
+	tm, err := pu.Termios()
+	if err != nil {
+		t.Fatalf("unable to get termios: %v", err)
+	}
+	if !tm.Echo || !tm.Canonical || tm.Intr != 0x03 {
+		t.Fatalf("unexpected default termios: %+v", tm)
+	}
+
+	tm.Echo = false
+	tm.Intr = 0x18 // Ctrl-X
+	tm.Susp = 0
+	if err := pu.SetTermios(tm); err != nil {
+		if isBSD() {
+			t.Skipf("unable to set termios through the master: %v", err)
+		}
+		t.Fatalf("unable to set termios: %v", err)
+	}
+	if got, err := pu.Termios(); err != nil || got != tm {
+		t.Fatalf("expected %+v, got %+v, %v", tm, got, err)
+	}
+	raw, err := pu.RawTermios()
+	if err != nil || raw.Lflag&unix.ECHO != 0 {
+		t.Fatalf("expected ECHO to be off in the raw termios: %+v, %v", raw, err)
+	}
+
+	if _, err := p.Write([]byte("secret\n")); err != nil {
+		t.Fatalf("unable to write pty: %v", err)
+	}
+	var out strings.Builder
+	for !strings.Contains(out.String(), "got secret") {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read pty: %v (got %q)", err, out.String())
+		}
+		out.WriteString(line)
+	}
+	if strings.Contains(strings.TrimSuffix(out.String(), "got secret\r\n"), "secret") {
+		t.Fatalf("expected no echo, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "-echo") || !strings.Contains(out.String(), "intr = ^X") {
+		t.Fatalf("expected stty to see the changes, got %q", out.String())
+	}
+}
+
+func TestTermiosRaw(t *testing.T) {
+	tm := crosspty.Termios{Echo: true, Canonical: true, UTF8: true, NLToCRNL: true, Intr: 0x03}.Raw()
+	want := crosspty.Termios{UTF8: true, NLToCRNL: true, Intr: 0x03}
+	if tm != want {
+		t.Fatalf("expected %+v, got %+v", want, tm)
+	}
+}
+
+func TestCommandConfigTermios_Unix(t *testing.T) {
+	tm := crosspty.DefaultTermios()
+	tm.Echo = false
+	tm.NLToCRNL = false
+	tm.Erase = 0x08
+	tm.UTF8 = runtime.GOOS == "linux"
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv:    []string{"sh", "-c", "stty -a; echo done"},
+		Termios: &tm,
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	reader := bufio.NewReader(p)
+	var out strings.Builder
+	for !strings.Contains(out.String(), "done\n") {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read pty: %v (got %q)", err, out.String())
+		}
+		out.WriteString(line)
+	}
+	if strings.Contains(out.String(), "\r\n") {
+		t.Fatalf("expected bare NL without ONLCR, got %q", out.String())
+	}
+	want := []string{"-echo ", "-onlcr", "erase = ^H"}
+	if runtime.GOOS == "linux" {
+		want = append(want, " iutf8")
+	}
+	for _, w := range want {
+		if !strings.Contains(out.String(), w) {
+			t.Fatalf("expected %q in stty output, got %q", w, out.String())
+		}
+	}
+}
+
+func TestCommandConfigTermiosUnsupported_Unix(t *testing.T) {
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+		t.Skip("IUTF8 is supported")
+	}
+	tm := crosspty.DefaultTermios()
+	tm.UTF8 = true
+	_, err := crosspty.Start(crosspty.CommandConfig{
+		Argv:    []string{"sh", "-c", "true"},
+		Termios: &tm,
+	})
+	if !errors.Is(err, crosspty.ErrTermiosNotSupported) {
+		t.Fatalf("expected ErrTermiosNotSupported, got %v", err)
+	}
+}
+
+func TestForegroundPgid_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", "echo ready; read line"},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+	pgid, err := p.(crosspty.PtyUnix).ForegroundPgid()
+	if err != nil || pgid != p.Pid() {
+		t.Fatalf("ForegroundPgid() = %d, %v, want %d", pgid, err, p.Pid())
+	}
+}
+
+func TestKillModeKillSessionUnsupported_Unix(t *testing.T) {
+	if runtime.GOOS == "linux" {
+		t.Skip("KillModeKillSession is supported")
+	}
+	_, err := crosspty.Start(crosspty.CommandConfig{
+		Argv:        []string{"sh", "-c", "true"},
+		CloseConfig: crosspty.CloseConfig{KillMode: crosspty.KillModeKillSession},
+	})
+	if !errors.Is(err, crosspty.ErrKillModeNotSupported) {
+		t.Fatalf("expected ErrKillModeNotSupported, got %v", err)
+	}
+}
+
+func TestKillModeKillCgroupUnsupported_Unix(t *testing.T) {
+	if runtime.GOOS == "linux" {
+		t.Skip("KillModeKillCgroup is supported")
+	}
+	_, err := crosspty.Start(crosspty.CommandConfig{
+		Argv:        []string{"sh", "-c", "true"},
+		CloseConfig: crosspty.CloseConfig{KillMode: crosspty.KillModeKillCgroup},
+	})
+	if !errors.Is(err, crosspty.ErrKillModeNotSupported) {
+		t.Fatalf("expected ErrKillModeNotSupported, got %v", err)
+	}
+}
+
+func TestCloseOnLeak_Unix(t *testing.T) {
+	leaked := make(chan []byte, 1)
+	pid := func() int {
+		p, err := crosspty.Start(crosspty.CommandConfig{
+			Argv: []string{"sh", "-c", "echo ready; while :; do sleep 1; done"},
+			CloseConfig: crosspty.CloseConfig{
+				CloseOnLeak: true,
+				OnLeak:      func(stack []byte) { leaked <- stack },
+			},
+		})
+		if err != nil {
+			t.Fatalf("unable to start pty: %v", err)
+		}
+		if _, ok := p.(crosspty.PtyUnix); !ok {
+			t.Fatal("expected the guarded Pty to implement PtyUnix")
+		}
+		if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+			t.Fatalf("unable to read pty: %v", err)
+		}
+		return p.Pid()
+	}()
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	deadline := time.Now().Add(5 * time.Second)
+	var stack []byte
+	for stack == nil {
+		runtime.GC()
+		select {
+		case stack = <-leaked:
+		case <-time.After(20 * time.Millisecond):
+		}
+		if stack == nil && time.Now().After(deadline) {
+			t.Fatal("expected OnLeak to be called")
+		}
+	}
+	if !strings.Contains(string(stack), "TestCloseOnLeak_Unix") {
+		t.Errorf("expected the stack to contain the test function:\n%s", stack)
+	}
+	if !waitForProcessState(pid, false, 5*time.Second) {
+		t.Fatalf("expected leaked subprocess %d to be closed", pid)
+	}
+}
+
+func TestCloseOnLeakAfterClose_Unix(t *testing.T) {
+	leaked := make(chan []byte, 1)
+	func() {
+		p, err := crosspty.Start(crosspty.CommandConfig{
+			Argv: []string{"sh", "-c", "while :; do sleep 1; done"},
+			CloseConfig: crosspty.CloseConfig{
+				CloseOnLeak: true,
+				OnLeak:      func(stack []byte) { leaked <- stack },
+			},
+		})
+		if err != nil {
+			t.Fatalf("unable to start pty: %v", err)
+		}
+		p.Close()
+	}()
+
+	for range 5 {
+		runtime.GC()
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case <-leaked:
+		t.Fatal("OnLeak called for a closed Pty")
+	default:
+	}
+}
+
+func TestCloseStepsSignals_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", `i=0; trap "i=1" INT; trap 'exit $((3 + i))' TERM; echo ready; while :; do sleep 0.1; done`},
+		CloseConfig: crosspty.CloseConfig{
+			Steps: []crosspty.CloseStep{
+				{Action: crosspty.CloseSignal, Signal: syscall.SIGINT, Wait: 300 * time.Millisecond},
+				{Action: crosspty.CloseSignal, Signal: syscall.SIGTERM, Wait: 2 * time.Second},
+				{Action: crosspty.CloseKill},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	// 4 if both traps ran.
+	if status := p.WaitStatus(); status.Code != 4 || !status.Terminated || status.Killed {
+		t.Fatalf("expected exit code 4 after SIGINT and SIGTERM, got %+v", status)
+	}
+}
+
+func TestCloseStepsWrite_Unix(t *testing.T) {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		t.Skip("CloseWrite is skipped on Darwin")
+	}
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", `echo ready; read line; [ "$line" = bye ] && exit 5; exit 1`},
+		CloseConfig: crosspty.CloseConfig{
+			Steps: []crosspty.CloseStep{
+				{Action: crosspty.CloseWrite, Data: []byte("bye\n"), Wait: 2 * time.Second},
+				{Action: crosspty.CloseKill},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if status := p.WaitStatus(); status.Code != 5 || status.Killed {
+		t.Fatalf("expected the shell to read the written line and exit 5, got %+v", status)
+	}
+}
+
+func TestCloseStepsWriteNotRead_Unix(t *testing.T) {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		t.Skip("CloseWrite is skipped on Darwin")
+	}
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", `trap "" HUP; echo ready; while :; do sleep 0.1; done`},
+		CloseConfig: crosspty.CloseConfig{
+			Steps: []crosspty.CloseStep{
+				// More than the PTY buffers, so the write blocks.
+				{Action: crosspty.CloseWrite, Data: []byte(strings.Repeat("x\n", 1<<20)), Wait: 200 * time.Millisecond},
+				{Action: crosspty.CloseKill},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+	rep, err := p.CloseWithReport()
+	if err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if len(rep.Steps) != 2 || rep.EndedBy != 1 {
+		t.Fatalf("expected the write to give up and the kill to end the subprocess, got %+v", rep)
+	}
+	if elapsed := rep.Steps[0].Elapsed; elapsed > 2*time.Second {
+		t.Fatalf("the write step took %v", elapsed)
+	}
+}
+
+func TestCloseStepsKill_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", `trap "" HUP INT; echo ready; while :; do sleep 0.1; done`},
+		CloseConfig: crosspty.CloseConfig{
+			Steps: []crosspty.CloseStep{
+				{Action: crosspty.CloseSignal, Signal: syscall.SIGINT, Wait: 100 * time.Millisecond},
+				{Action: crosspty.CloseHangup, Wait: 100 * time.Millisecond},
+				{Action: crosspty.CloseKill},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+	start := time.Now()
+	if err := p.Close(); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if status := p.WaitStatus(); status.Signal != syscall.SIGKILL || !status.Killed {
+		t.Fatalf("expected the kill step to kill the shell, got %+v", status)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected Close() to kill after the step waits, took %v", d)
+	}
+}
+
+func TestCloseKillTrapped_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", `trap "exit 7" TERM; echo ready; while :; do sleep 0.1; done`},
+		CloseConfig: crosspty.CloseConfig{
+			KillSignal: syscall.SIGTERM,
+			Steps:      []crosspty.CloseStep{{Action: crosspty.CloseKill}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	defer p.Close()
+
+	if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if status := p.WaitStatus(); status.Code != 7 || !status.Terminated || status.Killed {
+		t.Fatalf("expected a trapped KillSignal not to count as killed, got %+v", status)
+	}
+}
+
+func TestCloseWithReport_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", "echo ready; while :; do sleep 0.1; done"},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+
+	rep, err := p.CloseWithReport()
+	if err != nil || rep.Err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if rep.EndedBy != 0 || len(rep.Steps) != 1 || rep.Steps[0].Step.Action != crosspty.CloseHangup {
+		t.Fatalf("expected the hangup step to end the shell, got %+v", rep)
+	}
+	if rep.AlreadyExited || rep.CleanupErr != nil || rep.Elapsed < rep.Steps[0].Elapsed {
+		t.Fatalf("unexpected report %+v", rep)
+	}
+
+	again, err := p.CloseWithReport()
+	if err != nil || !reflect.DeepEqual(again, rep) {
+		t.Fatalf("expected the same report again, got %+v, %v", again, err)
+	}
+}
+
+func TestCloseWithReportKill_Unix(t *testing.T) {
+	p, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"sh", "-c", `trap "" HUP; echo ready; while :; do sleep 0.1; done`},
+		CloseConfig: crosspty.CloseConfig{
+			CloseTimeout: 2 * time.Second,
+			KillDelay:    200 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	if _, err := bufio.NewReader(p).ReadString('\n'); err != nil {
+		t.Fatalf("unable to read pty: %v", err)
+	}
+
+	rep, err := p.CloseWithReport()
+	if err != nil {
+		t.Fatalf("unable to close pty: %v", err)
+	}
+	if rep.EndedBy != 1 || len(rep.Steps) != 2 {
+		t.Fatalf("expected the kill step to end the shell, got %+v", rep)
+	}
+	if rep.Steps[0].Elapsed < 200*time.Millisecond {
+		t.Errorf("expected the hangup step to wait KillDelay, got %v", rep.Steps[0].Elapsed)
+	}
+	kill := rep.Steps[1]
+	if kill.Step.Action != crosspty.CloseKill || kill.Signal != syscall.SIGKILL || !kill.Delivered || kill.Err != nil {
+		t.Fatalf("unexpected kill step %+v", kill)
+	}
+}
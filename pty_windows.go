@@ -3,12 +3,15 @@
 package crosspty
 
 import (
+	"context"
 	"errors"
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unsafe"
 
 	"golang.org/x/sys/windows"
 )
@@ -21,18 +24,37 @@ type ptyWin struct {
 
 	closeCfg CloseConfig
 
-	exitCode int
-	exitch   chan any
-	closer   sync.Once
+	status  ExitStatus
+	usage   ResourceUsage
+	exitch  chan any
+	closech chan any
+	closer  sync.Once
+	closed  atomic.Bool // Close() finished
+
+	// Held for reading while conPty is used, so that Close() does not
+	// release it in the meantime. conPty is 0 after Close().
+	conPtyMu sync.RWMutex
+
+	report CloseReport // set by Close()
 
 	processId     uint32
 	processHandle windows.Handle
 
 	jobHandle windows.Handle
+
+	// Set by Close() before the corresponding step, read by processWaiter.
+	terminating atomic.Bool
+	killing     atomic.Bool
+}
+
+// ptyImpl is the interface implemented by ptyWin.
+type ptyImpl interface {
+	Pty
+	closeNotifier
 }
 
-func start(cc CommandConfig) (Pty, error) {
-	return StartWithSysProcAttr(cc, &syscall.SysProcAttr{})
+func start(ctx context.Context, cc CommandConfig) (Pty, error) {
+	return startWithSysProcAttr(ctx, cc, &syscall.SysProcAttr{})
 }
 
 // Windows only.
@@ -46,6 +68,10 @@ func start(cc CommandConfig) (Pty, error) {
 // If Token != 0, the default value of CommandConfig.Env is obtained from
 // CreateEnvironmentBlock using the given Token with bInherit = false.
 func StartWithSysProcAttr(cc CommandConfig, sys *syscall.SysProcAttr) (Pty, error) {
+	return startWithSysProcAttr(context.Background(), cc, sys)
+}
+
+func startWithSysProcAttr(ctx context.Context, cc CommandConfig, sys *syscall.SysProcAttr) (Pty, error) {
 	if sys == nil {
 		sys = &syscall.SysProcAttr{}
 	}
@@ -62,9 +88,14 @@ func StartWithSysProcAttr(cc CommandConfig, sys *syscall.SysProcAttr) (Pty, erro
 	if err != nil {
 		return nil, err
 	}
+	if cc.Termios != nil {
+		// ConPTY has no terminal attributes.
+		return nil, ErrTermiosNotSupported
+	}
 
 	p := &ptyWin{
 		exitch:   make(chan any),
+		closech:  make(chan any),
 		closeCfg: cc.CloseConfig,
 	}
 
@@ -73,6 +104,14 @@ func StartWithSysProcAttr(cc CommandConfig, sys *syscall.SysProcAttr) (Pty, erro
 		if err != nil {
 			return nil, err
 		}
+		if p.closeCfg.ParentDeathSignal != 0 {
+			// The handle is closed when the current process dies.
+			err = setKillOnJobClose(p.jobHandle)
+			if err != nil {
+				windows.CloseHandle(p.jobHandle)
+				return nil, err
+			}
+		}
 	}
 
 	err = p.openConPTY(cc.Size)
@@ -91,68 +130,147 @@ func StartWithSysProcAttr(cc CommandConfig, sys *syscall.SysProcAttr) (Pty, erro
 		return nil, err
 	}
 
-	return p, err
+	watchContext(ctx, p.closech, p.Close)
+	return guardLeak(p, p.closeCfg), nil
 }
 
-func (p *ptyWin) killProcess() error {
-	p.writePipe.Close() // trigger CTRL_CLOSE_EVENT
+func setKillOnJobClose(job windows.Handle) error {
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+		},
+	}
+	_, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+	return err
+}
 
-	childExited := false
+// killProcess runs CloseConfig.Steps until the subprocess exited.
+func (p *ptyWin) killProcess(rep *CloseReport) error {
+	rep.EndedBy = -1
 	select {
-	case <-time.After(p.closeCfg.KillDelay):
-		break
 	case <-p.exitch:
-		childExited = true
+		rep.AlreadyExited = true
+	default:
 	}
+	p.terminating.Store(true)
 
-	if p.closeCfg.KillMode == KillModeKillSubProcess {
-		if childExited {
-			return nil
+	steps := p.closeCfg.closeSteps()
+	for i, step := range steps {
+		stepStart := time.Now()
+		sr := CloseStepReport{Step: step}
+		switch step.Action {
+		case CloseWrite:
+			// May block if the subprocess does not read; Close() interrupts
+			// it at the end.
+			go p.Write(step.Data)
+		case CloseHangup:
+			p.writePipe.Close() // trigger CTRL_CLOSE_EVENT
+		case CloseKill:
+			p.killing.Store(true)
+			sr.Err = p.terminate()
 		}
-		// doc: https://learn.microsoft.com/en-us/windows/win32/api/processthreadsapi/nf-processthreadsapi-terminateprocess
-		err := windows.TerminateProcess(p.processHandle, p.closeCfg.KillExitCode)
-		if err != nil {
-			// > After a process has terminated, call to TerminateProcess with
-			// > open handles to the process fails with ERROR_ACCESS_DENIED (5)
-			// > error code.
-			if !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
-				return err
-			}
+		if sr.Err != nil {
+			sr.Elapsed = time.Since(stepStart)
+			rep.Steps = append(rep.Steps, sr)
+			return sr.Err
 		}
-	} else {
-		err := windows.TerminateJobObject(p.jobHandle, p.closeCfg.KillExitCode)
-		if err != nil {
-			return err
+
+		select {
+		case <-time.After(p.closeCfg.stepWait(steps, i)):
+			sr.Elapsed = time.Since(stepStart)
+			rep.Steps = append(rep.Steps, sr)
+		case <-p.exitch:
+			sr.Elapsed = time.Since(stepStart)
+			rep.Steps = append(rep.Steps, sr)
+			rep.EndedBy = i
+			if p.closeCfg.KillMode != KillModeKillSubProcess {
+				rep.CleanupErr = windows.TerminateJobObject(p.jobHandle, p.closeCfg.KillExitCode)
+			}
+			return rep.CleanupErr
 		}
 	}
+	return ErrKillTimeout
+}
 
-	if childExited {
-		return nil
+// terminate runs a CloseKill step.
+func (p *ptyWin) terminate() error {
+	if p.closeCfg.KillMode != KillModeKillSubProcess {
+		return windows.TerminateJobObject(p.jobHandle, p.closeCfg.KillExitCode)
 	}
-
-	select {
-	case <-time.After(p.closeCfg.CloseTimeout - p.closeCfg.KillDelay):
-		return ErrKillTimeout
-	case <-p.exitch:
-		return nil
+	// doc: https://learn.microsoft.com/en-us/windows/win32/api/processthreadsapi/nf-processthreadsapi-terminateprocess
+	err := windows.TerminateProcess(p.processHandle, p.closeCfg.KillExitCode)
+	if err != nil {
+		// > After a process has terminated, call to TerminateProcess with
+		// > open handles to the process fails with ERROR_ACCESS_DENIED (5)
+		// > error code.
+		if !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+			return err
+		}
 	}
+	return nil
 }
 
 func (p *ptyWin) Close() (err error) {
 	p.closer.Do(func() {
-		err = p.killProcess()
+		close(p.closech)
+		start := time.Now()
+		err = p.killProcess(&p.report)
+		p.report.Elapsed = time.Since(start)
+		p.report.Err = err
+		p.writePipe.Close()
 		p.attrList.Delete()
 		windows.CloseHandle(p.processHandle)
+		p.conPtyMu.Lock()
 		windows.ClosePseudoConsole(p.conPty)
+		p.conPty = 0
+		p.conPtyMu.Unlock()
 		p.readPipe.Close()
 		windows.CloseHandle(p.jobHandle)
+		p.closed.Store(true)
 	})
 	return
 }
 
+func (p *ptyWin) CloseWithReport() (CloseReport, error) {
+	p.Close() // waits for a running Close()
+	return p.report, p.report.Err
+}
+
 func (p *ptyWin) Wait() int {
 	<-p.exitch
-	return p.exitCode
+	return p.status.Code
+}
+
+func (p *ptyWin) WaitStatus() ExitStatus {
+	<-p.exitch
+	return p.status
+}
+
+func (p *ptyWin) Usage() (ResourceUsage, bool) {
+	select {
+	case <-p.exitch:
+		return p.usage, true
+	default:
+		return ResourceUsage{}, false
+	}
+}
+
+func (p *ptyWin) WaitContext(ctx context.Context) (int, error) {
+	select {
+	case <-p.exitch:
+		return p.status.Code, nil
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+}
+
+func (p *ptyWin) closeStarted() <-chan any {
+	return p.closech
+}
+
+func (p *ptyWin) CloseContext(ctx context.Context) error {
+	return closeContext(ctx, p.Close)
 }
 
 func (p *ptyWin) Read(d []byte) (n int, err error) {
@@ -160,19 +278,60 @@ func (p *ptyWin) Read(d []byte) (n int, err error) {
 	if errors.Is(err, windows.ERROR_BROKEN_PIPE) {
 		err = io.EOF
 	}
-	return
+	return n, closedErr(err)
 }
 
 func (p *ptyWin) Write(d []byte) (n int, err error) {
+	// The raw conn keeps the handle open while WriteFile runs. It only
+	// fails once the pipe was closed.
+	sc, err := p.writePipe.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
 	var n32 uint32
-	err = windows.WriteFile(windows.Handle(p.writePipe.Fd()), d, &n32, nil)
-	return int(n32), err
+	var writeErr error
+	err = sc.Write(func(fd uintptr) bool {
+		writeErr = windows.WriteFile(windows.Handle(fd), d, &n32, nil)
+		return true
+	})
+	if err != nil {
+		return 0, ErrClosed
+	}
+	return int(n32), writeErr
+}
+
+func (p *ptyWin) SetReadDeadline(_ time.Time) error {
+	return p.noDeadline()
+}
+
+func (p *ptyWin) SetWriteDeadline(_ time.Time) error {
+	return p.noDeadline()
+}
+
+func (p *ptyWin) SetDeadline(_ time.Time) error {
+	return p.noDeadline()
+}
+
+func (p *ptyWin) noDeadline() error {
+	if p.closed.Load() {
+		return ErrClosed
+	}
+	return os.ErrNoDeadline
 }
 
 func (p *ptyWin) Pid() int {
 	return int(p.processId)
 }
 
+func (p *ptyWin) State() State {
+	return ptyState(p.exitch, p.closech, p.closed.Load())
+}
+
 func (p *ptyWin) Resize(sz TermSize) error {
+	p.conPtyMu.RLock()
+	defer p.conPtyMu.RUnlock()
+	if p.conPty == 0 {
+		return ErrClosed
+	}
 	return windows.ResizePseudoConsole(p.conPty, windowsCoord(sz))
 }
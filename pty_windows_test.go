@@ -442,3 +442,26 @@ func TestNormalizeCommandConfig_WindowsEmptyEnvInjectDisablesPWD(t *testing.T) {
 		t.Fatalf("expected no PWD entry, got %v", got)
 	}
 }
+
+func TestCommandConfigTermios_Windows(t *testing.T) {
+	tm := crosspty.DefaultTermios()
+	_, err := crosspty.Start(crosspty.CommandConfig{
+		Argv:    []string{"cmd.exe", "/c", "exit 0"},
+		Termios: &tm,
+	})
+	if !errors.Is(err, crosspty.ErrTermiosNotSupported) {
+		t.Fatalf("expected ErrTermiosNotSupported, got %v", err)
+	}
+}
+
+func TestCloseStepsSignal_Windows(t *testing.T) {
+	_, err := crosspty.Start(crosspty.CommandConfig{
+		Argv: []string{"cmd", "/c", "ver"},
+		CloseConfig: crosspty.CloseConfig{
+			Steps: []crosspty.CloseStep{{Action: crosspty.CloseSignal, Signal: syscall.SIGTERM}},
+		},
+	})
+	if !errors.Is(err, crosspty.ErrInvalidCloseStep) {
+		t.Fatalf("expected ErrInvalidCloseStep, got %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Kodecable/crosspty"
+)
+
+type AsciicastConfig struct {
+	// Terminal size at the start of the recording, usually
+	// CommandConfig.Size.
+	// default: 24x80
+	Size crosspty.TermSize
+
+	// Recorded in the header. The asciicast format recommends only SHELL
+	// and TERM.
+	Env map[string]string
+
+	// default: time.Now()
+	Timestamp time.Time
+
+	Title   string
+	Command string
+
+	// Record Write() data as "i" events. Beware that this includes
+	// passwords typed by the user.
+	RecordInput bool
+
+	// Write an "x" event with the exit code when the Pty is closed, as
+	// asciicast v3 does. Some asciicast v2 players reject unknown events.
+	RecordExit bool
+}
+
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// NewAsciicast writes the asciicast v2 header to w and returns a Recorder
+// wrapping p.
+// See https://docs.asciinema.org/manual/asciicast/v2/
+func NewAsciicast(p crosspty.Pty, w io.Writer, cfg AsciicastConfig) (*Recorder, error) {
+	if cfg.Size.Rows == 0 || cfg.Size.Cols == 0 {
+		cfg.Size = crosspty.TermSize{Rows: 24, Cols: 80}
+	}
+	if cfg.Timestamp.IsZero() {
+		cfg.Timestamp = time.Now()
+	}
+
+	header, err := json.Marshal(asciicastHeader{
+		Version:   2,
+		Width:     int(cfg.Size.Cols),
+		Height:    int(cfg.Size.Rows),
+		Timestamp: cfg.Timestamp.Unix(),
+		Title:     cfg.Title,
+		Command:   cfg.Command,
+		Env:       cfg.Env,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(append(header, '\n')); err != nil {
+		return nil, err
+	}
+
+	return newRecorder(p, &asciicastEncoder{w: w, recordExit: cfg.RecordExit}, cfg.RecordInput), nil
+}
+
+type asciicastEncoder struct {
+	w          io.Writer
+	recordExit bool
+
+	// Incomplete UTF-8 sequences, kept until the rest arrives.
+	outTail []byte
+	inTail  []byte
+}
+
+func (e *asciicastEncoder) output(t time.Duration, d []byte) (err error) {
+	e.outTail, err = e.writeText(t, EventOutput, e.outTail, d)
+	return
+}
+
+func (e *asciicastEncoder) input(t time.Duration, d []byte) (err error) {
+	e.inTail, err = e.writeText(t, EventInput, e.inTail, d)
+	return
+}
+
+func (e *asciicastEncoder) resize(t time.Duration, sz crosspty.TermSize) error {
+	return e.writeEvent(t, EventResize, fmt.Sprintf("%dx%d", sz.Cols, sz.Rows))
+}
+
+// close writes out any incomplete UTF-8 sequence left, followed by the exit
+// event if enabled.
+func (e *asciicastEncoder) close(t time.Duration, code int, exited bool) error {
+	if len(e.outTail) != 0 {
+		if err := e.writeEvent(t, EventOutput, string(e.outTail)); err != nil {
+			return err
+		}
+	}
+	if len(e.inTail) != 0 {
+		if err := e.writeEvent(t, EventInput, string(e.inTail)); err != nil {
+			return err
+		}
+	}
+	if e.recordExit && exited {
+		return e.writeEvent(t, EventExit, strconv.Itoa(code))
+	}
+	return nil
+}
+
+// writeText writes tail+d as an event, except for a trailing incomplete
+// UTF-8 sequence, which is returned as the new tail.
+func (e *asciicastEncoder) writeText(t time.Duration, code EventType, tail, d []byte) ([]byte, error) {
+	buf := append(tail, d...)
+	keep := incompleteUTF8Suffix(buf)
+	if len(buf) > keep {
+		if err := e.writeEvent(t, code, string(buf[:len(buf)-keep])); err != nil {
+			return nil, err
+		}
+	}
+	return append([]byte(nil), buf[len(buf)-keep:]...), nil
+}
+
+func (e *asciicastEncoder) writeEvent(t time.Duration, code EventType, data string) error {
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	line := make([]byte, 0, len(d)+32)
+	line = append(line, '[')
+	line = strconv.AppendFloat(line, t.Seconds(), 'f', 6, 64)
+	line = append(line, ", \""...)
+	line = append(line, string(code)...)
+	line = append(line, "\", "...)
+	line = append(line, d...)
+	line = append(line, "]\n"...)
+	_, err = e.w.Write(line)
+	return err
+}
+
+// incompleteUTF8Suffix returns the length of a UTF-8 sequence at the end of
+// b that may be completed by more bytes.
+func incompleteUTF8Suffix(b []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c < utf8.RuneSelf {
+			return 0
+		}
+		if utf8.RuneStart(c) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// ReadAsciicast reads an asciicast v2 recording.
+func ReadAsciicast(r io.Reader) (*Recording, error) {
+	br := bufio.NewReader(r)
+
+	line, err := readNonEmptyLine(br)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("asciicast: read header: %w", err)
+	}
+	var header asciicastHeader
+	if err = json.Unmarshal(line, &header); err != nil {
+		return nil, fmt.Errorf("asciicast: bad header: %w", err)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("asciicast: unsupported version %d", header.Version)
+	}
+
+	rec := &Recording{
+		Size:    crosspty.TermSize{Rows: uint16(header.Height), Cols: uint16(header.Width)},
+		Env:     header.Env,
+		Title:   header.Title,
+		Command: header.Command,
+	}
+	if header.Timestamp != 0 {
+		rec.Timestamp = time.Unix(header.Timestamp, 0)
+	}
+
+	for {
+		line, err = readNonEmptyLine(br)
+		if err == io.EOF {
+			return rec, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		var raw []json.RawMessage
+		var ev Event
+		var t float64
+		if err = json.Unmarshal(line, &raw); err == nil {
+			if len(raw) != 3 {
+				err = errors.New("expected 3 elements")
+			}
+		}
+		if err == nil {
+			err = json.Unmarshal(raw[0], &t)
+		}
+		if err == nil {
+			err = json.Unmarshal(raw[1], &ev.Type)
+		}
+		if err == nil {
+			err = json.Unmarshal(raw[2], &ev.Data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("asciicast: bad event %q: %w", line, err)
+		}
+		ev.Time = time.Duration(t * float64(time.Second))
+		rec.Events = append(rec.Events, ev)
+	}
+}
+
+// readNonEmptyLine returns the next non-blank line without the line ending,
+// or io.EOF.
+func readNonEmptyLine(br *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := br.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) != 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package record_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/record"
+)
+
+// fakePty returns chunks from Read and remembers the last resize. Methods not
+// used by the tests panic on the nil embedded interface.
+type fakePty struct {
+	crosspty.Pty
+	chunks [][]byte
+	size   crosspty.TermSize
+	closed bool
+}
+
+func (f *fakePty) Read(d []byte) (int, error) {
+	if len(f.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(d, f.chunks[0])
+	f.chunks = f.chunks[1:]
+	return n, nil
+}
+
+func (f *fakePty) Write(d []byte) (int, error) {
+	return len(d), nil
+}
+
+func (f *fakePty) Resize(sz crosspty.TermSize) error {
+	f.size = sz
+	return nil
+}
+
+func (f *fakePty) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakePty) Wait() int {
+	return 3
+}
+
+func parseCast(t *testing.T, data []byte) (header map[string]any, events [][]any) {
+	t.Helper()
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	if !sc.Scan() {
+		t.Fatalf("missing header")
+	}
+	if err := json.Unmarshal(sc.Bytes(), &header); err != nil {
+		t.Fatalf("bad header %q: %v", sc.Text(), err)
+	}
+	for sc.Scan() {
+		var ev []any
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("bad event %q: %v", sc.Text(), err)
+		}
+		if len(ev) != 3 {
+			t.Fatalf("bad event %q", sc.Text())
+		}
+		events = append(events, ev)
+	}
+	return
+}
+
+func TestAsciicastRecord(t *testing.T) {
+	f := &fakePty{chunks: [][]byte{[]byte("hello\r\n"), []byte("$ ")}}
+	var out bytes.Buffer
+	rec, err := record.NewAsciicast(f, &out, record.AsciicastConfig{
+		Size:        crosspty.TermSize{Rows: 30, Cols: 100},
+		Env:         map[string]string{"TERM": "xterm-256color"},
+		Timestamp:   time.Unix(1700000000, 0),
+		RecordInput: true,
+		RecordExit:  true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var p crosspty.Pty = rec
+	if _, err := io.ReadAll(io.LimitReader(p, 9)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.Write([]byte("ls\r"))
+	p.Resize(crosspty.TermSize{Rows: 40, Cols: 120})
+	p.Close()
+
+	if err := rec.Err(); err != nil {
+		t.Fatalf("unexpected recording error: %v", err)
+	}
+	if !f.closed || f.size.Cols != 120 {
+		t.Fatalf("calls were not passed through")
+	}
+
+	header, events := parseCast(t, out.Bytes())
+	if header["version"] != 2.0 || header["width"] != 100.0 || header["height"] != 30.0 ||
+		header["timestamp"] != 1700000000.0 {
+		t.Fatalf("unexpected header: %v", header)
+	}
+	if env, _ := header["env"].(map[string]any); env["TERM"] != "xterm-256color" {
+		t.Fatalf("unexpected header env: %v", header["env"])
+	}
+
+	var got []string
+	last := 0.0
+	for _, ev := range events {
+		ts := ev[0].(float64)
+		if ts < last {
+			t.Fatalf("timestamps go backwards: %v", events)
+		}
+		last = ts
+		got = append(got, ev[1].(string)+":"+ev[2].(string))
+	}
+	want := []string{"o:hello\r\n", "o:$ ", "i:ls\r", "r:120x40", "x:3"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected events: %q", got)
+	}
+}
+
+func TestAsciicastSplitUTF8(t *testing.T) {
+	s := []byte("a€b")
+	f := &fakePty{chunks: [][]byte{s[:2], s[2:3], s[3:], {0xe2, 0x82}}}
+	var out bytes.Buffer
+	rec, err := record.NewAsciicast(f, &out, record.AsciicastConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	io.Copy(io.Discard, rec)
+	rec.Close()
+
+	header, events := parseCast(t, out.Bytes())
+	if header["width"] != 80.0 || header["height"] != 24.0 {
+		t.Fatalf("unexpected default size: %v", header)
+	}
+	var got []string
+	for _, ev := range events {
+		got = append(got, ev[2].(string))
+	}
+	// The trailing incomplete sequence is flushed on Close.
+	want := []string{"a", "€b", "��"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected events: %q", got)
+	}
+}
@@ -0,0 +1,403 @@
+package record
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+)
+
+type PlayerConfig struct {
+	// Playback speed factor, 2 plays twice as fast.
+	// default: 1
+	Speed float64
+
+	// Idle time between two events is clamped to MaxIdle. 0 means no limit.
+	MaxIdle time.Duration
+
+	// Start paused, see Player.Resume.
+	Paused bool
+}
+
+// Player replays a Recording as a fake crosspty.Pty, for testing terminal
+// frontends without spawning processes.
+//
+// Read returns the recorded output at the recorded time. The "process" exits
+// when playback reaches the exit event, or the last event if there is none;
+// Wait then returns the recorded exit code (0 if not recorded) and Read
+// returns io.EOF once all output has been read. Write discards its input and
+// Resize only records the new size, see Resizes.
+//
+// All times of Player are on the playback timeline: recording time with
+// MaxIdle applied, not scaled by Speed.
+type Player struct {
+	output   []Event // output events only
+	end      time.Duration
+	exitCode int
+	speed    float64
+
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced on every state change
+
+	next   int    // index of the next output event
+	unread []byte // unread part of output[next-1]
+
+	base   time.Duration // playback position at anchor
+	anchor time.Time
+	paused bool
+
+	readDeadline time.Time
+	resizes      []crosspty.TermSize
+
+	closed    bool
+	report    crosspty.CloseReport
+	status    crosspty.ExitStatus
+	startTime time.Time
+	exitTime  time.Time
+	exitch    chan any
+}
+
+func NewPlayer(rec *Recording, cfg PlayerConfig) *Player {
+	if cfg.Speed <= 0 {
+		cfg.Speed = 1
+	}
+
+	p := &Player{
+		speed:     cfg.Speed,
+		changed:   make(chan struct{}),
+		anchor:    time.Now(),
+		paused:    cfg.Paused,
+		startTime: time.Now(),
+		exitch:    make(chan any),
+		report:    crosspty.CloseReport{EndedBy: -1},
+	}
+
+	var last, now time.Duration
+	for _, ev := range rec.Events {
+		gap := max(ev.Time-last, 0)
+		if cfg.MaxIdle > 0 {
+			gap = min(gap, cfg.MaxIdle)
+		}
+		last = max(ev.Time, last)
+		now += gap
+		p.end = now
+
+		if ev.Type == EventOutput {
+			p.output = append(p.output, Event{Time: now, Type: ev.Type, Data: ev.Data})
+		} else if ev.Type == EventExit {
+			if code, err := strconv.Atoi(ev.Data); err == nil {
+				p.exitCode = code
+			}
+			break
+		}
+	}
+
+	go p.exiter()
+	return p
+}
+
+// exiter closes exitch when playback reaches the end.
+func (p *Player) exiter() {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		if p.position() >= p.end {
+			p.exit(crosspty.ExitStatus{Code: p.exitCode})
+			p.mu.Unlock()
+			return
+		}
+		delay, changed := p.delay(p.end), p.changed
+		p.mu.Unlock()
+
+		p.sleep(delay, changed)
+	}
+}
+
+// exit must be called with mu held.
+func (p *Player) exit(status crosspty.ExitStatus) {
+	select {
+	case <-p.exitch:
+	default:
+		p.status = status
+		p.exitTime = time.Now()
+		close(p.exitch)
+	}
+}
+
+// position must be called with mu held.
+func (p *Player) position() time.Duration {
+	if p.paused {
+		return p.base
+	}
+	return p.base + time.Duration(float64(time.Since(p.anchor))*p.speed)
+}
+
+// delay returns the real time until playback reaches t, or -1 if paused.
+// Must be called with mu held.
+func (p *Player) delay(t time.Duration) time.Duration {
+	if p.paused {
+		return -1
+	}
+	return time.Duration(float64(t-p.position()) / p.speed)
+}
+
+// sleep waits for delay (forever if negative) or a state change.
+func (p *Player) sleep(delay time.Duration, changed <-chan struct{}) {
+	if delay < 0 {
+		<-changed
+		return
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-changed:
+	}
+}
+
+// notify must be called with mu held.
+func (p *Player) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *Player) Read(d []byte) (int, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return 0, crosspty.ErrClosed
+		}
+		if !p.readDeadline.IsZero() && !time.Now().Before(p.readDeadline) {
+			p.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		pos := p.position()
+		if len(p.unread) == 0 && p.next < len(p.output) && p.output[p.next].Time <= pos {
+			p.unread = []byte(p.output[p.next].Data)
+			p.next++
+		}
+		if len(p.unread) != 0 {
+			n := copy(d, p.unread)
+			p.unread = p.unread[n:]
+			p.mu.Unlock()
+			return n, nil
+		}
+
+		until := p.end
+		if p.next < len(p.output) {
+			until = p.output[p.next].Time
+		} else if pos >= p.end {
+			p.exit(crosspty.ExitStatus{Code: p.exitCode})
+			p.mu.Unlock()
+			return 0, io.EOF
+		}
+
+		delay, changed := p.delay(until), p.changed
+		if !p.readDeadline.IsZero() {
+			if dl := time.Until(p.readDeadline); delay < 0 || dl < delay {
+				delay = dl
+			}
+		}
+		p.mu.Unlock()
+
+		p.sleep(delay, changed)
+	}
+}
+
+// Write discards d.
+func (p *Player) Write(d []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, crosspty.ErrClosed
+	}
+	return len(d), nil
+}
+
+func (p *Player) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readDeadline = t
+	p.notify()
+	return nil
+}
+
+// SetWriteDeadline does nothing, Write never blocks.
+func (p *Player) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (p *Player) SetDeadline(t time.Time) error {
+	return p.SetReadDeadline(t)
+}
+
+// Resize records sz, see Resizes.
+func (p *Player) Resize(sz crosspty.TermSize) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return crosspty.ErrClosed
+	}
+	p.resizes = append(p.resizes, sz)
+	return nil
+}
+
+// Resizes returns the sizes passed to Resize, oldest first.
+func (p *Player) Resizes() []crosspty.TermSize {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]crosspty.TermSize(nil), p.resizes...)
+}
+
+// Pause stops the playback clock.
+func (p *Player) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		return
+	}
+	p.base = p.position()
+	p.paused = true
+	p.notify()
+}
+
+func (p *Player) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return
+	}
+	p.anchor = time.Now()
+	p.paused = false
+	p.notify()
+}
+
+// Position returns the current playback position.
+func (p *Player) Position() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return min(p.position(), p.end)
+}
+
+// Duration returns the length of the playback.
+func (p *Player) Duration() time.Duration {
+	return p.end
+}
+
+// Seek moves the playback position to t. Output recorded before t that has
+// not been read yet is returned by Read without delay. When seeking back
+// before output that was already read, Read returns a terminal reset
+// ("\x1bc") followed by all output up to t, so the screen of the frontend
+// ends up as it was at t.
+//
+// Seeking does not bring an exited player back to life: Wait keeps
+// returning, and Read returns io.EOF after the output up to the end.
+func (p *Player) Seek(t time.Duration) {
+	t = min(max(t, 0), p.end)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.next > 0 && p.output[p.next-1].Time > t {
+		p.next = 0
+		p.unread = []byte("\x1bc")
+	}
+	p.base = t
+	p.anchor = time.Now()
+	p.notify()
+}
+
+// Close stops the playback. If the playback has not ended yet, the exit
+// status is reported as terminated and killed, with code -1.
+func (p *Player) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	select {
+	case <-p.exitch:
+		p.report.AlreadyExited = true
+	default:
+	}
+	p.exit(crosspty.ExitStatus{Code: -1, Terminated: true, Killed: true})
+	p.notify()
+	return nil
+}
+
+func (p *Player) CloseContext(ctx context.Context) error {
+	return p.Close()
+}
+
+// CloseWithReport reports no close steps, and EndedBy -1.
+func (p *Player) CloseWithReport() (crosspty.CloseReport, error) {
+	p.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.report, nil
+}
+
+func (p *Player) Wait() int {
+	return p.WaitStatus().Code
+}
+
+func (p *Player) WaitStatus() crosspty.ExitStatus {
+	<-p.exitch
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+func (p *Player) WaitContext(ctx context.Context) (int, error) {
+	select {
+	case <-p.exitch:
+		return p.Wait(), nil
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+}
+
+// Usage reports the real time of the playback, CPU times and MaxRSS are 0.
+func (p *Player) Usage() (crosspty.ResourceUsage, bool) {
+	select {
+	case <-p.exitch:
+	default:
+		return crosspty.ResourceUsage{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return crosspty.ResourceUsage{
+		StartTime: p.startTime,
+		ExitTime:  p.exitTime,
+		WallTime:  p.exitTime.Sub(p.startTime),
+	}, true
+}
+
+// Pid returns 0, there is no process.
+func (p *Player) Pid() int {
+	return 0
+}
+
+// State never reports StateClosing, since Close returns immediately.
+func (p *Player) State() crosspty.State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return crosspty.StateClosed
+	}
+	select {
+	case <-p.exitch:
+		return crosspty.StateExited
+	default:
+		return crosspty.StateRunning
+	}
+}
@@ -0,0 +1,191 @@
+package record_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/record"
+)
+
+const testCast = `{"version": 2, "width": 100, "height": 30, "env": {"TERM": "xterm"}}
+[0.0, "o", "hello "]
+[0.05, "i", "x"]
+[0.1, "o", "world"]
+[5.1, "o", "!"]
+[5.2, "x", "7"]
+`
+
+func TestReadAsciicast(t *testing.T) {
+	rec, err := record.ReadAsciicast(strings.NewReader(testCast))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.Size != (crosspty.TermSize{Rows: 30, Cols: 100}) || rec.Env["TERM"] != "xterm" {
+		t.Fatalf("unexpected header: %+v", rec)
+	}
+	if len(rec.Events) != 5 || rec.Events[2].Time != 100*time.Millisecond ||
+		rec.Events[2].Type != record.EventOutput || rec.Events[2].Data != "world" {
+		t.Fatalf("unexpected events: %+v", rec.Events)
+	}
+
+	_, err = record.ReadAsciicast(strings.NewReader(`{"version": 1}`))
+	if err == nil {
+		t.Fatalf("expected version 1 to be rejected")
+	}
+	_, err = record.ReadAsciicast(strings.NewReader(testCast + "[1.0, \"o\"]\n"))
+	if err == nil {
+		t.Fatalf("expected a bad event to be rejected")
+	}
+}
+
+func TestPlayerReplay(t *testing.T) {
+	rec, err := record.ReadAsciicast(strings.NewReader(testCast))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The 5s idle gap is clamped to 100ms.
+	var p crosspty.Pty = record.NewPlayer(rec, record.PlayerConfig{MaxIdle: 100 * time.Millisecond})
+	defer p.Close()
+
+	begin := time.Now()
+	out, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	elapsed := time.Since(begin)
+	if string(out) != "hello world!" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	if elapsed < 250*time.Millisecond || elapsed > 3*time.Second {
+		t.Fatalf("unexpected playback time: %v", elapsed)
+	}
+
+	if code := p.Wait(); code != 7 {
+		t.Fatalf("expected the recorded exit code, got %d", code)
+	}
+	if st := p.WaitStatus(); st.Terminated || st.Killed {
+		t.Fatalf("unexpected exit status: %+v", st)
+	}
+
+	p.Resize(crosspty.TermSize{Rows: 10, Cols: 20})
+	if rs := p.(*record.Player).Resizes(); len(rs) != 1 || rs[0].Cols != 20 {
+		t.Fatalf("expected the resize to be recorded, got %v", rs)
+	}
+}
+
+func TestPlayerSpeed(t *testing.T) {
+	rec := &record.Recording{Events: []record.Event{
+		{Time: 0, Type: record.EventOutput, Data: "a"},
+		{Time: 2 * time.Second, Type: record.EventOutput, Data: "b"},
+	}}
+	p := record.NewPlayer(rec, record.PlayerConfig{Speed: 20})
+	defer p.Close()
+
+	begin := time.Now()
+	out, _ := io.ReadAll(p)
+	if string(out) != "ab" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("speed was not applied, took %v", elapsed)
+	}
+	if code := p.Wait(); code != 0 {
+		t.Fatalf("expected exit code 0 without exit event, got %d", code)
+	}
+}
+
+func TestPlayerPauseSeek(t *testing.T) {
+	rec := &record.Recording{Events: []record.Event{
+		{Time: 0, Type: record.EventOutput, Data: "a"},
+		{Time: time.Hour, Type: record.EventOutput, Data: "b"},
+		{Time: 2 * time.Hour, Type: record.EventOutput, Data: "c"},
+	}}
+	p := record.NewPlayer(rec, record.PlayerConfig{Paused: true})
+	defer p.Close()
+
+	buf := make([]byte, 16)
+	n, err := p.Read(buf)
+	if err != nil || string(buf[:n]) != "a" {
+		t.Fatalf("expected output at time 0 while paused, got %q, %v", buf[:n], err)
+	}
+
+	p.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err = p.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded while paused, got %v", err)
+	}
+	p.SetReadDeadline(time.Time{})
+
+	p.Seek(90 * time.Minute)
+	n, err = p.Read(buf)
+	if err != nil || string(buf[:n]) != "b" {
+		t.Fatalf("expected output before the seek target, got %q, %v", buf[:n], err)
+	}
+	if pos := p.Position(); pos != 90*time.Minute {
+		t.Fatalf("unexpected position while paused: %v", pos)
+	}
+
+	// Seeking back resets the screen and replays.
+	p.Seek(0)
+	var got bytes.Buffer
+	for range 2 {
+		n, err = p.Read(buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got.Write(buf[:n])
+	}
+	if got.String() != "\x1bca" {
+		t.Fatalf("unexpected replay: %q", got.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = p.WaitContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the paused player not to exit, got %v", err)
+	}
+
+	p.Seek(p.Duration())
+	p.Resume()
+	out, err := io.ReadAll(p)
+	if err != nil || string(out) != "bc" {
+		t.Fatalf("unexpected output after seeking to the end: %q, %v", out, err)
+	}
+
+	if st := p.State(); st != crosspty.StateExited {
+		t.Fatalf("expected the player to have exited, got %v", st)
+	}
+	p.Close()
+	if st := p.State(); st != crosspty.StateClosed {
+		t.Fatalf("expected the player to be closed, got %v", st)
+	}
+	if st := p.WaitStatus(); st.Code != 0 || st.Killed {
+		t.Fatalf("Close after the end should not change the exit status: %+v", st)
+	}
+	if _, err = p.Read(buf); !errors.Is(err, crosspty.ErrClosed) {
+		t.Fatalf("expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestPlayerCloseEarly(t *testing.T) {
+	rec := &record.Recording{Events: []record.Event{
+		{Time: time.Hour, Type: record.EventExit, Data: "0"},
+	}}
+	p := record.NewPlayer(rec, record.PlayerConfig{})
+	rep, err := p.CloseWithReport()
+	if err != nil || rep.EndedBy != -1 || rep.AlreadyExited || len(rep.Steps) != 0 {
+		t.Fatalf("unexpected close report %+v, %v", rep, err)
+	}
+	if st := p.WaitStatus(); st.Code != -1 || !st.Killed {
+		t.Fatalf("expected a killed status, got %+v", st)
+	}
+	if _, ok := p.Usage(); !ok {
+		t.Fatalf("expected usage after exit")
+	}
+}
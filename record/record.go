@@ -0,0 +1,52 @@
+// Package record records crosspty sessions and plays them back.
+//
+// A Recorder wraps a crosspty.Pty and still satisfies the crosspty.Pty
+// interface, so it can be dropped into existing io.Copy plumbing:
+//
+//	p, _ := crosspty.Start(cc)
+//	rec, _ := record.NewAsciicast(p, f, record.AsciicastConfig{Size: cc.Size})
+//	defer rec.Close()
+//	go io.Copy(rec, os.Stdin)
+//	io.Copy(os.Stdout, rec)
+//
+// Errors writing the recording never fail Pty I/O; check Err() instead.
+package record
+
+import (
+	"time"
+
+	"github.com/Kodecable/crosspty"
+)
+
+type EventType string
+
+const (
+	EventOutput EventType = "o" // Data is PTY output.
+	EventInput  EventType = "i" // Data is input written to the PTY.
+	EventResize EventType = "r" // Data is "COLSxROWS".
+	EventMarker EventType = "m" // Data is a label, may be empty.
+	EventExit   EventType = "x" // Data is the exit code in decimal.
+)
+
+type Event struct {
+	// Time since the start of the recording.
+	Time time.Duration
+	Type EventType
+	Data string
+}
+
+// Recording is a recorded session, as returned by the readers.
+type Recording struct {
+	// Terminal size at the start of the recording.
+	Size crosspty.TermSize
+
+	// Zero if unknown.
+	Timestamp time.Time
+
+	Env     map[string]string
+	Title   string
+	Command string
+
+	// Ordered by Time.
+	Events []Event
+}
@@ -0,0 +1,126 @@
+package record
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+)
+
+// encoder writes a recording format. Calls are serialized by Recorder.
+// Times are relative to the start of the recording.
+type encoder interface {
+	output(t time.Duration, d []byte) error
+	// Only called if input recording is enabled.
+	input(t time.Duration, d []byte) error
+	resize(t time.Duration, sz crosspty.TermSize) error
+	// Called once after the Pty was closed. exited is false if the exit
+	// code is unknown.
+	close(t time.Duration, code int, exited bool) error
+}
+
+// Recorder wraps a Pty and records the session, see NewAsciicast,
+// NewTtyrec and NewScript.
+type Recorder struct {
+	crosspty.Pty
+
+	recordInput bool
+	start       time.Time
+
+	mu       sync.Mutex
+	enc      encoder
+	err      error
+	finished bool
+}
+
+func newRecorder(p crosspty.Pty, enc encoder, recordInput bool) *Recorder {
+	return &Recorder{
+		Pty:         p,
+		recordInput: recordInput,
+		start:       time.Now(),
+		enc:         enc,
+	}
+}
+
+// Err returns the first error that occurred while writing the recording.
+// Nothing is recorded after an error.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) Read(d []byte) (n int, err error) {
+	n, err = r.Pty.Read(d)
+	if n > 0 {
+		r.record(func(t time.Duration) error { return r.enc.output(t, d[:n]) })
+	}
+	return
+}
+
+func (r *Recorder) Write(d []byte) (n int, err error) {
+	n, err = r.Pty.Write(d)
+	if n > 0 && r.recordInput {
+		r.record(func(t time.Duration) error { return r.enc.input(t, d[:n]) })
+	}
+	return
+}
+
+func (r *Recorder) Resize(sz crosspty.TermSize) error {
+	err := r.Pty.Resize(sz)
+	if err == nil {
+		r.record(func(t time.Duration) error { return r.enc.resize(t, sz) })
+	}
+	return err
+}
+
+// Close closes the Pty and finishes the recording (e.g. writes the exit
+// code). The underlying writers are not closed.
+func (r *Recorder) Close() error {
+	err := r.Pty.Close()
+	r.finish(err)
+	return err
+}
+
+func (r *Recorder) CloseWithReport() (crosspty.CloseReport, error) {
+	rep, err := r.Pty.CloseWithReport()
+	r.finish(err)
+	return rep, err
+}
+
+func (r *Recorder) CloseContext(ctx context.Context) error {
+	err := r.Pty.CloseContext(ctx)
+	if ctx.Err() == nil {
+		r.finish(err)
+	}
+	return err
+}
+
+// finish is called after the Pty was closed. closeErr is the result of
+// Close; only a successful Close guarantees that Wait will not block.
+func (r *Recorder) finish(closeErr error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return
+	}
+	r.finished = true
+
+	code := -1
+	if closeErr == nil {
+		code = r.Pty.Wait()
+	}
+	if r.err == nil {
+		r.err = r.enc.close(time.Since(r.start), code, closeErr == nil)
+	}
+}
+
+func (r *Recorder) record(fn func(t time.Duration) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil || r.finished {
+		return
+	}
+	r.err = fn(time.Since(r.start))
+}
@@ -0,0 +1,299 @@
+package record
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+)
+
+type ScriptConfig struct {
+	// Recorded in the header, usually CommandConfig.Size.
+	// default: 24x80
+	Size crosspty.TermSize
+
+	// default: time.Now()
+	Timestamp time.Time
+
+	// Recorded in the header.
+	Command string
+	Term    string
+
+	// Log Write() data to the same typescript, like script --log-io. This
+	// switches the timing file to the advanced ("multi-stream") format,
+	// which also records resizes and the exit code, and needs scriptreplay
+	// from util-linux 2.35 or later. Beware that this includes passwords
+	// typed by the user.
+	RecordInput bool
+}
+
+// The timestamp format of util-linux script.
+const scriptTimeLayout = "2006-01-02 15:04:05-07:00"
+
+// NewScript returns a Recorder wrapping p that writes a typescript and a
+// timing file like util-linux script --log-timing, for scriptreplay.
+func NewScript(p crosspty.Pty, typescript, timing io.Writer, cfg ScriptConfig) (*Recorder, error) {
+	if cfg.Size.Rows == 0 || cfg.Size.Cols == 0 {
+		cfg.Size = crosspty.TermSize{Rows: 24, Cols: 80}
+	}
+	if cfg.Timestamp.IsZero() {
+		cfg.Timestamp = time.Now()
+	}
+	ts := cfg.Timestamp.Format(scriptTimeLayout)
+
+	var header strings.Builder
+	fmt.Fprintf(&header, "Script started on %s [", ts)
+	if cfg.Command != "" {
+		fmt.Fprintf(&header, "COMMAND=\"%s\" ", cfg.Command)
+	}
+	if cfg.Term != "" {
+		fmt.Fprintf(&header, "TERM=\"%s\" ", cfg.Term)
+	}
+	fmt.Fprintf(&header, "COLUMNS=\"%d\" LINES=\"%d\"]\n", cfg.Size.Cols, cfg.Size.Rows)
+	if _, err := io.WriteString(typescript, header.String()); err != nil {
+		return nil, err
+	}
+
+	if cfg.RecordInput {
+		var info strings.Builder
+		fmt.Fprintf(&info, "H 0.000000 START_TIME %s\n", ts)
+		if cfg.Term != "" {
+			fmt.Fprintf(&info, "H 0.000000 TERM %s\n", cfg.Term)
+		}
+		fmt.Fprintf(&info, "H 0.000000 COLUMNS %d\n", cfg.Size.Cols)
+		fmt.Fprintf(&info, "H 0.000000 LINES %d\n", cfg.Size.Rows)
+		if cfg.Command != "" {
+			fmt.Fprintf(&info, "H 0.000000 COMMAND %s\n", cfg.Command)
+		}
+		if _, err := io.WriteString(timing, info.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	return newRecorder(p, &scriptEncoder{
+		typescript: typescript,
+		timing:     timing,
+		advanced:   cfg.RecordInput,
+	}, cfg.RecordInput), nil
+}
+
+type scriptEncoder struct {
+	typescript io.Writer
+	timing     io.Writer
+	advanced   bool
+
+	last time.Duration // time of the previous timing entry
+}
+
+// delta returns the seconds since the previous timing entry.
+func (e *scriptEncoder) delta(t time.Duration) string {
+	d := t - e.last
+	e.last = t
+	return strconv.FormatFloat(d.Seconds(), 'f', 6, 64)
+}
+
+func (e *scriptEncoder) write(t time.Duration, stream byte, d []byte) error {
+	if _, err := e.typescript.Write(d); err != nil {
+		return err
+	}
+	var err error
+	if e.advanced {
+		_, err = fmt.Fprintf(e.timing, "%c %s %d\n", stream, e.delta(t), len(d))
+	} else {
+		_, err = fmt.Fprintf(e.timing, "%s %d\n", e.delta(t), len(d))
+	}
+	return err
+}
+
+func (e *scriptEncoder) output(t time.Duration, d []byte) error {
+	return e.write(t, 'O', d)
+}
+
+func (e *scriptEncoder) input(t time.Duration, d []byte) error {
+	return e.write(t, 'I', d)
+}
+
+func (e *scriptEncoder) resize(t time.Duration, sz crosspty.TermSize) error {
+	if !e.advanced {
+		return nil
+	}
+	_, err := fmt.Fprintf(e.timing, "S %s SIGWINCH ROWS=%d COLS=%d\n", e.delta(t), sz.Rows, sz.Cols)
+	return err
+}
+
+func (e *scriptEncoder) close(t time.Duration, code int, exited bool) error {
+	ts := time.Now().Format(scriptTimeLayout)
+	var err error
+	if exited {
+		_, err = fmt.Fprintf(e.typescript, "\nScript done on %s [COMMAND_EXIT_CODE=\"%d\"]\n", ts, code)
+	} else {
+		_, err = fmt.Fprintf(e.typescript, "\nScript done on %s\n", ts)
+	}
+	if err != nil || !e.advanced {
+		return err
+	}
+
+	_, err = fmt.Fprintf(e.timing, "H 0.000000 DURATION %.6f\n", t.Seconds())
+	if err == nil && exited {
+		_, err = fmt.Fprintf(e.timing, "H 0.000000 EXIT_CODE %d\n", code)
+	}
+	return err
+}
+
+var (
+	scriptHeaderRe   = regexp.MustCompile(`^Script started on (.*?)(?: \[(.*)\])?$`)
+	scriptHeaderKVRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	scriptExitCodeRe = regexp.MustCompile(`COMMAND_EXIT_CODE="(-?\d+)"`)
+)
+
+// ReadScript reads a typescript and timing file written by util-linux
+// script --log-timing, in either the classic or the advanced format. In the
+// advanced format, input must be logged to the same typescript as output
+// (script --log-io or -B).
+func ReadScript(typescript, timing io.Reader) (*Recording, error) {
+	rec := &Recording{}
+	ts := bufio.NewReader(typescript)
+
+	if line, err := ts.Peek(len("Script started on ")); err == nil && string(line) == "Script started on " {
+		header, _ := ts.ReadString('\n')
+		parseScriptHeader(rec, strings.TrimRight(header, "\r\n"))
+	}
+
+	var now time.Duration
+	exited := false
+	sc := bufio.NewScanner(timing)
+	for sc.Scan() {
+		line := sc.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		stream := "O"
+		if len(fields) == 2 {
+			// classic: delay length
+			fields = append([]string{stream}, fields...)
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("script: bad timing line %q", line)
+		}
+		stream = fields[0]
+		delay, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("script: bad timing line %q: %w", line, err)
+		}
+
+		switch stream {
+		case "O", "I":
+			now += time.Duration(delay * float64(time.Second))
+			n, err := strconv.Atoi(fields[2])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("script: bad timing line %q", line)
+			}
+			data, err := io.ReadAll(io.LimitReader(ts, int64(n)))
+			if err == nil && len(data) != n {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return nil, fmt.Errorf("script: read typescript: %w", err)
+			}
+			typ := EventOutput
+			if stream == "I" {
+				typ = EventInput
+			}
+			rec.Events = append(rec.Events, Event{Time: now, Type: typ, Data: string(data)})
+		case "S":
+			now += time.Duration(delay * float64(time.Second))
+			if fields[2] != "SIGWINCH" {
+				continue
+			}
+			var sz crosspty.TermSize
+			for _, f := range fields[3:] {
+				k, v, _ := strings.Cut(f, "=")
+				n, _ := strconv.ParseUint(v, 10, 16)
+				switch k {
+				case "ROWS":
+					sz.Rows = uint16(n)
+				case "COLS":
+					sz.Cols = uint16(n)
+				}
+			}
+			rec.Events = append(rec.Events, Event{
+				Time: now,
+				Type: EventResize,
+				Data: fmt.Sprintf("%dx%d", sz.Cols, sz.Rows),
+			})
+		case "H":
+			// H delay NAME value, the value may contain spaces.
+			var value string
+			if parts := strings.SplitN(strings.TrimSpace(line), " ", 4); len(parts) == 4 {
+				value = parts[3]
+			}
+			switch fields[2] {
+			case "START_TIME":
+				if t, err := time.Parse(scriptTimeLayout, value); err == nil {
+					rec.Timestamp = t
+				}
+			case "TERM":
+				rec.Env = map[string]string{"TERM": value}
+			case "COLUMNS":
+				n, _ := strconv.ParseUint(value, 10, 16)
+				rec.Size.Cols = uint16(n)
+			case "LINES":
+				n, _ := strconv.ParseUint(value, 10, 16)
+				rec.Size.Rows = uint16(n)
+			case "COMMAND":
+				rec.Command = value
+			case "EXIT_CODE":
+				rec.Events = append(rec.Events, Event{Time: now, Type: EventExit, Data: value})
+				exited = true
+			}
+		default:
+			return nil, fmt.Errorf("script: bad timing line %q", line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("script: read timing: %w", err)
+	}
+
+	if !exited {
+		// The classic format only has the exit code in the footer.
+		rest, err := io.ReadAll(ts)
+		if err != nil {
+			return nil, fmt.Errorf("script: read typescript: %w", err)
+		}
+		if m := scriptExitCodeRe.FindSubmatch(rest); m != nil {
+			rec.Events = append(rec.Events, Event{Time: now, Type: EventExit, Data: string(m[1])})
+		}
+	}
+	return rec, nil
+}
+
+func parseScriptHeader(rec *Recording, header string) {
+	m := scriptHeaderRe.FindStringSubmatch(header)
+	if m == nil {
+		return
+	}
+	if t, err := time.Parse(scriptTimeLayout, m[1]); err == nil {
+		rec.Timestamp = t
+	}
+	for _, kv := range scriptHeaderKVRe.FindAllStringSubmatch(m[2], -1) {
+		switch kv[1] {
+		case "COMMAND":
+			rec.Command = kv[2]
+		case "TERM":
+			rec.Env = map[string]string{"TERM": kv[2]}
+		case "COLUMNS":
+			n, _ := strconv.ParseUint(kv[2], 10, 16)
+			rec.Size.Cols = uint16(n)
+		case "LINES":
+			n, _ := strconv.ParseUint(kv[2], 10, 16)
+			rec.Size.Rows = uint16(n)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package record_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/record"
+)
+
+func TestReadScriptClassic(t *testing.T) {
+	// Written by util-linux 2.38 script --log-timing.
+	typescript := "Script started on 2026-10-16 18:57:45+00:00 [COMMAND=\"echo hi; exit 3\" <not executed on terminal>]\n" +
+		"hi\r\n\nScript done on 2026-10-16 18:57:45+00:00 [COMMAND_EXIT_CODE=\"3\"]\n"
+	timing := "0.000874 4\n"
+
+	rec, err := record.ReadScript(strings.NewReader(typescript), strings.NewReader(timing))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rec.Command != "echo hi; exit 3" || rec.Timestamp.Unix() != 1792177065 {
+		t.Fatalf("unexpected header: %+v", rec)
+	}
+	want := []record.Event{
+		{Time: 874 * time.Microsecond, Type: record.EventOutput, Data: "hi\r\n"},
+		{Time: 874 * time.Microsecond, Type: record.EventExit, Data: "3"},
+	}
+	if len(rec.Events) != len(want) || rec.Events[0] != want[0] || rec.Events[1] != want[1] {
+		t.Fatalf("unexpected events: %+v", rec.Events)
+	}
+}
+
+func TestScriptRoundTrip(t *testing.T) {
+	for _, advanced := range []bool{false, true} {
+		f := &fakePty{chunks: [][]byte{[]byte("$ "), []byte("ls\r\n")}}
+		var typescript, timing bytes.Buffer
+		rec, err := record.NewScript(f, &typescript, &timing, record.ScriptConfig{
+			Size:        crosspty.TermSize{Rows: 30, Cols: 100},
+			Command:     "sh",
+			Term:        "xterm",
+			RecordInput: advanced,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		buf := make([]byte, 16)
+		rec.Read(buf)
+		rec.Write([]byte("ls\r"))
+		rec.Read(buf)
+		rec.Resize(crosspty.TermSize{Rows: 40, Cols: 120})
+		rec.Close()
+		if err := rec.Err(); err != nil {
+			t.Fatalf("unexpected recording error: %v", err)
+		}
+
+		got, err := record.ReadScript(&typescript, &timing)
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n%s", err, timing.String())
+		}
+		if got.Size != (crosspty.TermSize{Rows: 30, Cols: 100}) || got.Command != "sh" ||
+			got.Env["TERM"] != "xterm" {
+			t.Fatalf("unexpected header: %+v", got)
+		}
+
+		var events []string
+		for _, ev := range got.Events {
+			events = append(events, string(ev.Type)+":"+ev.Data)
+		}
+		want := "o:$ |o:ls\r\n|x:3"
+		if advanced {
+			want = "o:$ |i:ls\r|o:ls\r\n|r:120x40|x:3"
+		}
+		if strings.Join(events, "|") != want {
+			t.Fatalf("unexpected events (advanced %v): %q", advanced, events)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package record
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+)
+
+// NewTtyrec returns a Recorder wrapping p that writes ttyrec frames to w.
+// ttyrec only records output; resizes and the exit code are not recorded.
+// The file can be played with ttyplay.
+func NewTtyrec(p crosspty.Pty, w io.Writer) *Recorder {
+	return newRecorder(p, &ttyrecEncoder{w: w, start: time.Now()}, false)
+}
+
+type ttyrecEncoder struct {
+	w     io.Writer
+	start time.Time
+}
+
+// Each frame is a header of three little-endian uint32 (seconds and
+// microseconds of the wall clock, data length) followed by the data.
+func (e *ttyrecEncoder) output(t time.Duration, d []byte) error {
+	ts := e.start.Add(t)
+	frame := make([]byte, 12, 12+len(d))
+	binary.LittleEndian.PutUint32(frame[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(frame[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(frame[8:], uint32(len(d)))
+	frame = append(frame, d...)
+	_, err := e.w.Write(frame)
+	return err
+}
+
+func (e *ttyrecEncoder) input(t time.Duration, d []byte) error {
+	return nil
+}
+
+func (e *ttyrecEncoder) resize(t time.Duration, sz crosspty.TermSize) error {
+	return nil
+}
+
+func (e *ttyrecEncoder) close(t time.Duration, code int, exited bool) error {
+	return nil
+}
+
+// ReadTtyrec reads a ttyrec file. Timestamp of the result is the time of the
+// first frame; Size is unknown (zero).
+func ReadTtyrec(r io.Reader) (*Recording, error) {
+	rec := &Recording{}
+	var header [12]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return rec, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("ttyrec: read frame header: %w", err)
+		}
+
+		ts := time.Unix(int64(binary.LittleEndian.Uint32(header[0:])),
+			int64(binary.LittleEndian.Uint32(header[4:]))*1000)
+		// Not make([]byte, n), n is not trusted.
+		n := int64(binary.LittleEndian.Uint32(header[8:]))
+		data, err := io.ReadAll(io.LimitReader(r, n))
+		if err == nil && int64(len(data)) != n {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ttyrec: read frame: %w", err)
+		}
+
+		if rec.Timestamp.IsZero() {
+			rec.Timestamp = ts
+		}
+		rec.Events = append(rec.Events, Event{
+			Time: ts.Sub(rec.Timestamp),
+			Type: EventOutput,
+			Data: string(data),
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package record_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Kodecable/crosspty/record"
+)
+
+func TestTtyrecRoundTrip(t *testing.T) {
+	f := &fakePty{chunks: [][]byte{[]byte("hello "), []byte("world")}}
+	var out bytes.Buffer
+	rec := record.NewTtyrec(f, &out)
+
+	buf := make([]byte, 16)
+	rec.Read(buf)
+	rec.Write([]byte("not recorded"))
+	rec.Read(buf)
+	rec.Close()
+
+	// 12 bytes of header per frame
+	if out.Len() != 2*12+len("hello world") {
+		t.Fatalf("unexpected ttyrec size %d", out.Len())
+	}
+
+	got, err := record.ReadTtyrec(&out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Timestamp.IsZero() || len(got.Events) != 2 ||
+		got.Events[0].Data != "hello " || got.Events[1].Data != "world" ||
+		got.Events[0].Time != 0 || got.Events[1].Time < 0 {
+		t.Fatalf("unexpected recording: %+v", got)
+	}
+
+	_, err = record.ReadTtyrec(bytes.NewReader([]byte{0, 0, 0, 0, 0, 0, 0, 0, 9, 0, 0, 0, 'x'}))
+	if err == nil {
+		t.Fatalf("expected a truncated frame to be rejected")
+	}
+}
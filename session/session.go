@@ -0,0 +1,362 @@
+// Package session keeps a process running behind a crosspty.Pty while
+// clients attach and detach, like tmux or screen.
+//
+//	p, _ := crosspty.Start(cc)
+//	s, _ := session.New(p, session.Config{ResizePolicy: session.ResizeSmallest})
+//	defer s.Close()
+//
+//	// for each connection
+//	c := s.Attach(clientSize)
+//	defer c.Detach()
+//	go io.Copy(c, conn)
+//	io.Copy(conn, c)
+//
+// The Session reads the Pty all the time, also when no client is attached,
+// so the process never blocks on a full PTY buffer. Detaching never closes
+// the Pty, only Session.Close does.
+package session
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/Kodecable/crosspty"
+)
+
+var (
+	ErrDetached   = errors.New("session: client detached")
+	ErrSlowClient = errors.New("session: client too slow, detached")
+)
+
+type ResizePolicy uint8
+
+const (
+	// The Pty has the smallest rows and the smallest cols of all attached
+	// clients, so every client can display the whole screen.
+	ResizeSmallest ResizePolicy = iota
+
+	// The Pty has the size of the client that attached or resized last.
+	ResizeLatest
+
+	// The Pty has Config.Size; client sizes are ignored.
+	ResizeFixed
+)
+
+type Config struct {
+	// Output kept for replay to newly attached clients, in bytes. Replay
+	// starts at a line boundary, so it may be shorter.
+	// Negative disables replay. At most MaxClientBuffer, as the replay is
+	// buffered for the client.
+	// default: 256 KiB
+	MaxScrollback int
+
+	// Output buffered for an attached client that does not read. A client
+	// that falls further behind is detached with ErrSlowClient.
+	// default: 1 MiB
+	MaxClientBuffer int
+
+	ResizePolicy ResizePolicy
+
+	// The Pty is resized to Size on New, if not zero. Required for
+	// ResizeFixed.
+	Size crosspty.TermSize
+}
+
+// Session owns a Pty and its attached clients.
+// Thread-safe.
+type Session struct {
+	pty crosspty.Pty
+	cfg Config
+
+	mu         sync.Mutex
+	scrollback []byte
+	clients    map[*Client]struct{}
+	seq        uint64 // to find the latest client
+	size       crosspty.TermSize
+	readErr    error
+	closed     bool
+	done       chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// New starts reading p. The Session takes ownership of p: call Session.Close
+// instead of p.Close. On error, p is left alone.
+func New(p crosspty.Pty, cfg Config) (*Session, error) {
+	if cfg.ResizePolicy == ResizeFixed && (cfg.Size.Rows == 0 || cfg.Size.Cols == 0) {
+		return nil, errors.New("session: ResizeFixed requires Size")
+	}
+	if cfg.MaxScrollback == 0 {
+		cfg.MaxScrollback = 256 << 10
+	}
+	if cfg.MaxClientBuffer <= 0 {
+		cfg.MaxClientBuffer = 1 << 20
+	}
+	cfg.MaxScrollback = min(cfg.MaxScrollback, cfg.MaxClientBuffer)
+
+	s := &Session{
+		pty:     p,
+		cfg:     cfg,
+		clients: make(map[*Client]struct{}),
+		done:    make(chan struct{}),
+	}
+	if cfg.Size.Rows != 0 && cfg.Size.Cols != 0 {
+		if p.Resize(cfg.Size) == nil {
+			s.size = cfg.Size
+		}
+	}
+
+	go s.reader()
+	return s, nil
+}
+
+func (s *Session) reader() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := s.pty.Read(buf)
+
+		s.mu.Lock()
+		if n > 0 {
+			s.appendScrollback(buf[:n])
+			for c := range s.clients {
+				if len(c.buf)+n > s.cfg.MaxClientBuffer {
+					s.detach(c, ErrSlowClient)
+					continue
+				}
+				c.buf = append(c.buf, buf[:n]...)
+				c.notify()
+			}
+		}
+		if err != nil {
+			s.readErr = err
+			for c := range s.clients {
+				s.detach(c, io.EOF)
+			}
+			close(s.done)
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// appendScrollback must be called with mu held.
+func (s *Session) appendScrollback(d []byte) {
+	if s.cfg.MaxScrollback < 0 {
+		return
+	}
+	s.scrollback = append(s.scrollback, d...)
+	if over := len(s.scrollback) - s.cfg.MaxScrollback; over > 0 {
+		// Drop a whole line at least, so that replay does not start in the
+		// middle of a line or an escape sequence.
+		if i := bytes.IndexByte(s.scrollback[over:], '\n'); i >= 0 {
+			over += i + 1
+		} else {
+			over = len(s.scrollback)
+		}
+		s.scrollback = append(s.scrollback[:0], s.scrollback[over:]...)
+	}
+}
+
+// Pty returns the owned Pty, e.g. to Wait for the process.
+func (s *Session) Pty() crosspty.Pty {
+	return s.pty
+}
+
+// Done is closed when no more output can be read from the Pty, usually
+// because the process exited. Attached clients read io.EOF after the
+// remaining output.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Clients returns the number of attached clients.
+func (s *Session) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// Size returns the last size the Session resized the Pty to, or zero if it
+// did not resize the Pty yet.
+func (s *Session) Size() crosspty.TermSize {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Attach a client. It reads the scrollback first, then the live output. size
+// is the size of the client terminal, used by the resize policy.
+//
+// If the Pty output already ended, the client reads the scrollback, then
+// io.EOF. If the Session is closed, it reads the scrollback, then
+// ErrDetached.
+func (s *Session) Attach(size crosspty.TermSize) *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &Client{
+		s:    s,
+		size: size,
+		buf:  append([]byte(nil), s.scrollback...),
+	}
+	c.ready.L = &s.mu
+	if s.readErr != nil {
+		c.err = io.EOF
+		return c
+	}
+	if s.closed {
+		c.err = ErrDetached
+		return c
+	}
+
+	s.seq++
+	c.seq = s.seq
+	s.clients[c] = struct{}{}
+	s.applyResizePolicy()
+	return c
+}
+
+// Close detaches all clients and closes the Pty, see crosspty.Pty.Close.
+func (s *Session) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		for c := range s.clients {
+			s.detach(c, ErrDetached)
+		}
+		s.mu.Unlock()
+
+		s.closeErr = s.pty.Close()
+	})
+	return s.closeErr
+}
+
+// detach must be called with mu held.
+func (s *Session) detach(c *Client, err error) {
+	if _, ok := s.clients[c]; !ok {
+		return
+	}
+	delete(s.clients, c)
+	c.err = err
+	if err == ErrSlowClient {
+		c.buf = nil
+	}
+	c.notify()
+	s.applyResizePolicy()
+}
+
+// applyResizePolicy must be called with mu held.
+func (s *Session) applyResizePolicy() {
+	if s.closed {
+		return
+	}
+
+	var size crosspty.TermSize
+	switch s.cfg.ResizePolicy {
+	case ResizeSmallest:
+		for c := range s.clients {
+			if c.size.Rows == 0 || c.size.Cols == 0 {
+				continue
+			}
+			if size.Rows == 0 || c.size.Rows < size.Rows {
+				size.Rows = c.size.Rows
+			}
+			if size.Cols == 0 || c.size.Cols < size.Cols {
+				size.Cols = c.size.Cols
+			}
+		}
+	case ResizeLatest:
+		var latest uint64
+		for c := range s.clients {
+			if c.size.Rows != 0 && c.size.Cols != 0 && c.seq > latest {
+				latest, size = c.seq, c.size
+			}
+		}
+	case ResizeFixed:
+		return
+	}
+
+	// Keep the size when the last client detaches.
+	if size.Rows == 0 || size.Cols == 0 || size == s.size {
+		return
+	}
+	if s.pty.Resize(size) == nil {
+		s.size = size
+	}
+}
+
+// Client is an attached client.
+// Read and Write are thread-safe and may be called concurrently.
+type Client struct {
+	s     *Session
+	ready sync.Cond // on s.mu, broadcast when buf or err changes
+
+	// Protected by s.mu.
+	size crosspty.TermSize
+	seq  uint64
+	buf  []byte
+	err  error
+}
+
+// must be called with s.mu held.
+func (c *Client) notify() {
+	c.ready.Broadcast()
+}
+
+// Read returns the output of the Pty. After the client is detached it returns
+// the output buffered so far, then io.EOF if the Pty output ended, or
+// ErrDetached, or ErrSlowClient without the buffered output.
+func (c *Client) Read(d []byte) (int, error) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	for len(c.buf) == 0 && c.err == nil {
+		c.ready.Wait()
+	}
+	if len(c.buf) != 0 {
+		n := copy(d, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return 0, c.err
+}
+
+// Write sends input to the Pty.
+func (c *Client) Write(d []byte) (int, error) {
+	c.s.mu.Lock()
+	err := c.err
+	c.s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return c.s.pty.Write(d)
+}
+
+// Resize reports a new size of the client terminal. The Pty is resized as
+// the resize policy says.
+func (c *Client) Resize(size crosspty.TermSize) error {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.size = size
+	c.s.seq++
+	c.seq = c.s.seq
+	c.s.applyResizePolicy()
+	return nil
+}
+
+// Detach the client. The Pty is not closed. Pending and future Read calls
+// return the already buffered output and then ErrDetached.
+// Can be called multiple times.
+func (c *Client) Detach() {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	c.s.detach(c, ErrDetached)
+}
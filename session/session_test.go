@@ -0,0 +1,313 @@
+package session_test
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/session"
+)
+
+// fakePty reads output from a pipe and records input and resizes. Methods not
+// used by the tests panic on the nil embedded interface.
+type fakePty struct {
+	crosspty.Pty
+	out *io.PipeReader
+
+	mu      sync.Mutex
+	input   strings.Builder
+	resizes []crosspty.TermSize
+	closed  bool
+}
+
+func newFake() (*fakePty, *io.PipeWriter) {
+	r, w := io.Pipe()
+	return &fakePty{out: r}, w
+}
+
+func (f *fakePty) Read(d []byte) (int, error) {
+	return f.out.Read(d)
+}
+
+func (f *fakePty) Write(d []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.input.Write(d)
+}
+
+func (f *fakePty) Resize(sz crosspty.TermSize) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resizes = append(f.resizes, sz)
+	return nil
+}
+
+func (f *fakePty) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	return f.out.Close()
+}
+
+func (f *fakePty) lastResize() crosspty.TermSize {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.resizes) == 0 {
+		return crosspty.TermSize{}
+	}
+	return f.resizes[len(f.resizes)-1]
+}
+
+// readUntil reads from r until the output contains s.
+func readUntil(t *testing.T, r io.Reader, s string) string {
+	t.Helper()
+	var out strings.Builder
+	buf := make([]byte, 1024)
+	for !strings.Contains(out.String(), s) {
+		n, err := r.Read(buf)
+		out.Write(buf[:n])
+		if err != nil {
+			t.Fatalf("read %q: %v (got %q)", s, err, out.String())
+		}
+	}
+	return out.String()
+}
+
+func size(rows, cols uint16) crosspty.TermSize {
+	return crosspty.TermSize{Rows: rows, Cols: cols}
+}
+
+func TestAttachReplayAndLive(t *testing.T) {
+	f, w := newFake()
+	s, _ := session.New(f, session.Config{})
+	defer s.Close()
+
+	w.Write([]byte("old output\r\n"))
+	c1 := s.Attach(size(24, 80))
+	if got := readUntil(t, c1, "old output"); got != "old output\r\n" {
+		t.Fatalf("unexpected replay: %q", got)
+	}
+
+	c2 := s.Attach(size(24, 80))
+	readUntil(t, c2, "old output\r\n")
+
+	w.Write([]byte("live"))
+	readUntil(t, c1, "live")
+	readUntil(t, c2, "live")
+
+	c1.Write([]byte("a"))
+	c2.Write([]byte("b"))
+	f.mu.Lock()
+	input := f.input.String()
+	f.mu.Unlock()
+	if input != "ab" {
+		t.Fatalf("unexpected input: %q", input)
+	}
+}
+
+func TestDetachKeepsProcess(t *testing.T) {
+	f, w := newFake()
+	s, _ := session.New(f, session.Config{})
+	defer s.Close()
+
+	c := s.Attach(size(24, 80))
+	c.Detach()
+	if _, err := c.Read(make([]byte, 16)); !errors.Is(err, session.ErrDetached) {
+		t.Fatalf("expected ErrDetached, got %v", err)
+	}
+	if _, err := c.Write([]byte("x")); !errors.Is(err, session.ErrDetached) {
+		t.Fatalf("expected ErrDetached on write, got %v", err)
+	}
+	if s.Clients() != 0 {
+		t.Fatalf("expected no attached clients")
+	}
+
+	// Output keeps being read without clients.
+	w.Write([]byte("while detached\r\n"))
+	f.mu.Lock()
+	closed := f.closed
+	f.mu.Unlock()
+	if closed {
+		t.Fatalf("detach closed the Pty")
+	}
+
+	c = s.Attach(size(24, 80))
+	readUntil(t, c, "while detached")
+}
+
+func TestDetachWakesAllReads(t *testing.T) {
+	f, _ := newFake()
+	s, _ := session.New(f, session.Config{})
+	defer s.Close()
+
+	c := s.Attach(size(24, 80))
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := c.Read(make([]byte, 16))
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) // let both block in Read
+	c.Detach()
+
+	for range 2 {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, session.ErrDetached) {
+				t.Fatalf("expected ErrDetached, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("a pending Read did not return after Detach")
+		}
+	}
+}
+
+func TestScrollbackLimit(t *testing.T) {
+	f, w := newFake()
+	s, _ := session.New(f, session.Config{MaxScrollback: 16})
+	defer s.Close()
+
+	w.Write([]byte("line 1\r\nline 2\r\nline 3\r\n"))
+	w.Write([]byte("x")) // the reader is done with the previous write
+	c := s.Attach(size(24, 80))
+	if got := readUntil(t, c, "x"); got != "line 3\r\nx" {
+		t.Fatalf("expected replay to start at a line boundary, got %q", got)
+	}
+}
+
+func TestScrollbackAboveClientBuffer(t *testing.T) {
+	f, w := newFake()
+	s, _ := session.New(f, session.Config{MaxScrollback: 64, MaxClientBuffer: 16})
+	defer s.Close()
+
+	w.Write([]byte(strings.Repeat("0123456\r\n", 4)))
+	w.Write([]byte("x"))
+	c := s.Attach(size(24, 80))
+	w.Write([]byte("y"))
+	if got := readUntil(t, c, "xy"); got != "0123456\r\nxy" {
+		t.Fatalf("expected replay within MaxClientBuffer, got %q", got)
+	}
+}
+
+func TestSessionEnd(t *testing.T) {
+	f, w := newFake()
+	s, _ := session.New(f, session.Config{})
+	defer s.Close()
+
+	c := s.Attach(size(24, 80))
+	w.Write([]byte("bye"))
+	w.Close()
+
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("session did not end")
+	}
+	out, err := io.ReadAll(c)
+	if err != nil || string(out) != "bye" {
+		t.Fatalf("expected remaining output then EOF, got %q, %v", out, err)
+	}
+
+	late := s.Attach(size(24, 80))
+	out, err = io.ReadAll(late)
+	if err != nil || string(out) != "bye" {
+		t.Fatalf("expected replay then EOF for a late client, got %q, %v", out, err)
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	f, w := newFake()
+	s, _ := session.New(f, session.Config{MaxClientBuffer: 8})
+	defer s.Close()
+
+	c := s.Attach(size(24, 80))
+	w.Write([]byte("0123456789"))
+	w.Write([]byte("x"))
+	if _, err := io.ReadAll(c); !errors.Is(err, session.ErrSlowClient) {
+		t.Fatalf("expected ErrSlowClient, got %v", err)
+	}
+}
+
+func TestResizePolicy(t *testing.T) {
+	f, _ := newFake()
+	s, _ := session.New(f, session.Config{ResizePolicy: session.ResizeSmallest})
+	c1 := s.Attach(size(50, 100))
+	c2 := s.Attach(size(40, 120))
+	if got := f.lastResize(); got != size(40, 100) {
+		t.Fatalf("smallest: unexpected size %v", got)
+	}
+	c2.Detach()
+	if got := f.lastResize(); got != size(50, 100) {
+		t.Fatalf("smallest after detach: unexpected size %v", got)
+	}
+	c1.Detach()
+	if got := s.Size(); got != size(50, 100) {
+		t.Fatalf("expected the size to stay without clients, got %v", got)
+	}
+	s.Close()
+
+	f, _ = newFake()
+	s, _ = session.New(f, session.Config{ResizePolicy: session.ResizeLatest})
+	c1 = s.Attach(size(50, 100))
+	c2 = s.Attach(size(40, 120))
+	if got := f.lastResize(); got != size(40, 120) {
+		t.Fatalf("latest: unexpected size %v", got)
+	}
+	c1.Resize(size(30, 90))
+	if got := f.lastResize(); got != size(30, 90) {
+		t.Fatalf("latest after resize: unexpected size %v", got)
+	}
+	c1.Detach()
+	if got := f.lastResize(); got != size(40, 120) {
+		t.Fatalf("latest after detach: unexpected size %v", got)
+	}
+	s.Close()
+
+	f, _ = newFake()
+	s, _ = session.New(f, session.Config{ResizePolicy: session.ResizeFixed, Size: size(25, 81)})
+	s.Attach(size(50, 100)).Resize(size(10, 10))
+	f.mu.Lock()
+	resizes := f.resizes
+	f.mu.Unlock()
+	if len(resizes) != 1 || resizes[0] != size(25, 81) {
+		t.Fatalf("fixed: unexpected resizes %v", resizes)
+	}
+	s.Close()
+
+	if _, err := session.New(f, session.Config{ResizePolicy: session.ResizeFixed}); err == nil {
+		t.Fatalf("fixed: expected an error without Size")
+	}
+}
+
+func TestSessionRealPty(t *testing.T) {
+	argv := []string{"sh", "-c", "read line; echo got $line"}
+	if runtime.GOOS == "windows" {
+		argv = []string{"cmd.exe", "/c", "set /p line= & echo got %line%"}
+	}
+	p, err := crosspty.Start(crosspty.CommandConfig{Argv: argv})
+	if err != nil {
+		t.Fatalf("unable to start pty: %v", err)
+	}
+	s, _ := session.New(p, session.Config{})
+	defer s.Close()
+
+	c := s.Attach(size(24, 80))
+	c.Write([]byte("hello\r\n"))
+	readUntil(t, c, "got hello")
+	c.Detach()
+
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("session did not end")
+	}
+	if code := s.Pty().Wait(); code != 0 {
+		t.Fatalf("unexpected exit code %d", code)
+	}
+}
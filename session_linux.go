@@ -0,0 +1,51 @@
+//go:build linux
+
+package crosspty
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ttyDevice returns the device number of the PTY slave, or 0 if unknown.
+func (p *ptyUnix) ttyDevice() (dev uint64) {
+	p.control(func(fd int) error {
+		n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+		if err != nil {
+			return err
+		}
+		var st unix.Stat_t
+		if err := unix.Stat("/dev/pts/"+strconv.Itoa(n), &st); err != nil {
+			return err
+		}
+		dev = uint64(st.Rdev) // uint32 on mips
+		return nil
+	})
+	return
+}
+
+// sessionProcesses returns the live (not zombie) processes in session sid
+// or with tty as controlling terminal, except the caller.
+func sessionProcesses(sid int, tty uint64) []int {
+	pids, err := listPIDs()
+	if err != nil {
+		return nil
+	}
+	self := os.Getpid()
+	var found []int
+	for _, pid := range pids {
+		if pid == self {
+			continue
+		}
+		st, ok := readProcStat(pid)
+		if !ok || st.state == 'Z' || st.state == 'X' {
+			continue
+		}
+		if st.session == sid || (tty != 0 && st.ttyNr == tty) {
+			found = append(found, pid)
+		}
+	}
+	return found
+}
@@ -0,0 +1,363 @@
+// Package sshserver serves SSH "session" channels with processes running on
+// a crosspty.Pty.
+//
+//	srv := sshserver.New(sshserver.Config{
+//		Shell: crosspty.CommandConfig{Argv: []string{"bash", "-l"}},
+//	})
+//	for {
+//		conn, _ := listener.Accept()
+//		go srv.ServeConn(conn, serverConfig) // serverConfig handles auth
+//	}
+//
+// The process always runs on a PTY, also when the client did not request
+// one, so stdout and stderr are merged. On Unix, EOF from the client is
+// passed on as the EOF character of the PTY (usually ^D). Without
+// "pty-req", input and output pass through the PTY unchanged on Unix.
+package sshserver
+
+import (
+	"io"
+	"maps"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/internal/procsignal"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type Config struct {
+	// The process for "shell" requests. Size is taken from "pty-req", and
+	// TERM and accepted "env" variables are added to EnvInject.
+	Shell crosspty.CommandConfig
+
+	// Builds the process for "exec" requests. The same changes as for Shell
+	// are applied to the result.
+	// default: Shell with Argv replaced by sh -c command (cmd.exe /c command
+	// on Windows)
+	Exec func(command string) crosspty.CommandConfig
+
+	// Reports whether the client may set the variable name with an "env"
+	// request. Be careful, variables like LD_PRELOAD allow running arbitrary
+	// code.
+	// default: LANG and LC_*
+	AcceptEnv func(name string) bool
+}
+
+type Server struct {
+	cfg Config
+}
+
+func New(cfg Config) *Server {
+	if cfg.Exec == nil {
+		shell := cfg.Shell
+		cfg.Exec = func(command string) crosspty.CommandConfig {
+			cc := shell
+			if runtime.GOOS == "windows" {
+				cc.Argv = []string{"cmd.exe", "/c", command}
+			} else {
+				cc.Argv = []string{"/bin/sh", "-c", command}
+			}
+			return cc
+		}
+	}
+	if cfg.AcceptEnv == nil {
+		cfg.AcceptEnv = func(name string) bool {
+			return name == "LANG" || strings.HasPrefix(name, "LC_")
+		}
+	}
+	return &Server{cfg: cfg}
+}
+
+// ServeConn runs the SSH handshake on c and serves its channels until the
+// connection is closed. Channels other than "session" and global requests
+// are rejected.
+func (s *Server) ServeConn(c net.Conn, config *ssh.ServerConfig) error {
+	conn, chans, reqs, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	defer conn.Close()
+
+	go ssh.DiscardRequests(reqs)
+	var wg sync.WaitGroup
+	for nc := range chans {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.HandleChannel(nc)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// HandleChannel serves a "session" channel until it is closed, and rejects
+// other channel types. Use it to integrate with an existing ssh.ServerConn.
+func (s *Server) HandleChannel(nc ssh.NewChannel) {
+	if nc.ChannelType() != "session" {
+		nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	sess := &session{srv: s, ch: ch, env: map[string]string{}}
+	sess.serve(reqs)
+}
+
+type session struct {
+	srv *Server
+	ch  ssh.Channel
+
+	size   crosspty.TermSize
+	env    map[string]string
+	ptyReq bool // a "pty-req" was received
+
+	mu  sync.Mutex
+	pty crosspty.Pty
+}
+
+// RFC 4254 request payloads.
+type ptyRequest struct {
+	Term   string
+	Cols   uint32
+	Rows   uint32
+	Width  uint32
+	Height uint32
+	Modes  string
+}
+
+type windowChange struct {
+	Cols   uint32
+	Rows   uint32
+	Width  uint32
+	Height uint32
+}
+
+type envRequest struct {
+	Name  string
+	Value string
+}
+
+type execRequest struct {
+	Command string
+}
+
+type signalRequest struct {
+	Signal string
+}
+
+type exitStatus struct {
+	Status uint32
+}
+
+type exitSignal struct {
+	Signal     string
+	CoreDumped bool
+	Message    string
+	Lang       string
+}
+
+func (s *session) serve(reqs <-chan *ssh.Request) {
+	defer func() {
+		s.mu.Lock()
+		p := s.pty
+		s.mu.Unlock()
+		if p != nil {
+			p.Close()
+		}
+		s.ch.Close()
+	}()
+
+	for req := range reqs {
+		ok := false
+		switch req.Type {
+		case "pty-req":
+			var r ptyRequest
+			if ssh.Unmarshal(req.Payload, &r) == nil {
+				s.size = termSize(r.Cols, r.Rows)
+				s.env["TERM"] = r.Term
+				s.ptyReq = true
+				ok = true
+			}
+		case "window-change":
+			var r windowChange
+			if ssh.Unmarshal(req.Payload, &r) == nil {
+				s.resize(termSize(r.Cols, r.Rows))
+				ok = true
+			}
+		case "env":
+			var r envRequest
+			if ssh.Unmarshal(req.Payload, &r) == nil && s.srv.cfg.AcceptEnv(r.Name) {
+				s.env[r.Name] = r.Value
+				ok = true
+			}
+		case "shell":
+			ok = s.start(s.srv.cfg.Shell)
+		case "exec":
+			var r execRequest
+			if ssh.Unmarshal(req.Payload, &r) == nil {
+				ok = s.start(s.srv.cfg.Exec(r.Command))
+			}
+		case "signal":
+			var r signalRequest
+			if ssh.Unmarshal(req.Payload, &r) == nil {
+				s.mu.Lock()
+				p := s.pty
+				s.mu.Unlock()
+				ok = p != nil && procsignal.Send(p, r.Signal) == nil
+			}
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+}
+
+func termSize(cols, rows uint32) crosspty.TermSize {
+	return crosspty.TermSize{Rows: uint16(min(rows, 0xFFFF)), Cols: uint16(min(cols, 0xFFFF))}
+}
+
+func (s *session) resize(size crosspty.TermSize) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = size
+	if s.pty != nil && size.Rows != 0 && size.Cols != 0 {
+		s.pty.Resize(size)
+	}
+}
+
+// start starts the process, unless a process was already started.
+func (s *session) start(cc crosspty.CommandConfig) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pty != nil {
+		return false
+	}
+
+	if s.size.Rows != 0 && s.size.Cols != 0 {
+		cc.Size = s.size
+	}
+	if !s.ptyReq {
+		// The client expects a plain byte stream, e.g. ssh host cat < file.
+		cc.Termios = binaryTermios()
+	}
+	inject := make(map[string]string, len(cc.EnvInject)+len(s.env))
+	maps.Copy(inject, cc.EnvInject)
+	maps.Copy(inject, s.env)
+	cc.EnvInject = inject
+
+	p, err := crosspty.Start(cc)
+	if err != nil {
+		return false
+	}
+	s.pty = p
+
+	go s.input(p, !s.ptyReq)
+	go s.output(p)
+	return true
+}
+
+// input copies the channel to the PTY. A PTY can not be half-closed, so EOF
+// from the client is passed on as the EOF character, which ends the input of
+// e.g. cat in canonical mode. binary reports whether the client did not
+// request a PTY, see binaryTermios.
+func (s *session) input(p crosspty.Pty, binary bool) {
+	w := &inputWriter{p: p, binary: binary}
+	w.eof, w.lnext = specialChars(p)
+	if _, err := io.Copy(w, s.ch); err != nil {
+		return
+	}
+	w.close()
+}
+
+// maxLine is the length after which inputWriter submits a line in binary
+// mode, below the smallest MAX_CANON allowed by POSIX.
+const maxLine = 254
+
+// inputWriter writes client input to the PTY and tracks the current line,
+// for close. In binary mode, it escapes the characters that are special in
+// canonical mode with the literal-next character, and submits long lines
+// with the EOF character before they hit MAX_CANON.
+type inputWriter struct {
+	p      crosspty.Pty
+	binary bool
+	eof    byte // 0 if there is no EOF character
+	lnext  byte
+	line   int // bytes in the current line
+	buf    []byte
+}
+
+func (w *inputWriter) Write(b []byte) (int, error) {
+	if !w.binary || w.eof == 0 || w.lnext == 0 {
+		n, err := w.p.Write(b)
+		if n > 0 {
+			if c := b[n-1]; c == '\n' || c == '\r' {
+				w.line = 0
+			} else {
+				w.line = 1
+			}
+		}
+		return n, err
+	}
+
+	w.buf = w.buf[:0]
+	for _, c := range b {
+		if c == '\n' {
+			w.buf = append(w.buf, c)
+			w.line = 0
+			continue
+		}
+		if c < 0x20 || c == 0x7f || c == 0xff {
+			w.buf = append(w.buf, w.lnext)
+		}
+		w.buf = append(w.buf, c)
+		if w.line++; w.line == maxLine {
+			w.buf = append(w.buf, w.eof)
+			w.line = 0
+		}
+	}
+	if _, err := w.p.Write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// close passes EOF on. The first EOF character only submits a pending
+// partial line.
+func (w *inputWriter) close() {
+	if w.eof == 0 {
+		return
+	}
+	if w.line > 0 {
+		w.p.Write([]byte{w.eof})
+	}
+	w.p.Write([]byte{w.eof})
+}
+
+// output copies PTY output to the channel, then reports the exit status and
+// closes the channel.
+func (s *session) output(p crosspty.Pty) {
+	io.Copy(s.ch, p)
+
+	st := p.WaitStatus()
+	if name := procsignal.Name(st.Signal); st.Signal != 0 && name != "" {
+		s.ch.SendRequest("exit-signal", false, ssh.Marshal(exitSignal{
+			Signal:     name,
+			CoreDumped: st.CoreDumped,
+		}))
+	} else {
+		code := uint32(255)
+		if st.Code >= 0 {
+			code = uint32(st.Code)
+		}
+		s.ch.SendRequest("exit-status", false, ssh.Marshal(exitStatus{Status: code}))
+	}
+	s.ch.Close()
+}
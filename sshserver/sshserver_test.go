@@ -0,0 +1,205 @@
+package sshserver_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/sshserver"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// dial serves one connection with srv on loopback and returns its client.
+func dial(t *testing.T, srv *sshserver.Server) *ssh.Client {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("signer: %v", err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	// Not net.Pipe: it is unbuffered, and both sides send their version
+	// first.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		if sc, err := l.Accept(); err == nil {
+			srv.ServeConn(sc, serverConfig)
+		}
+	}()
+	cc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	conn, chans, reqs, err := ssh.NewClientConn(cc, l.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	client := ssh.NewClient(conn, chans, reqs)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestExecWithPtyAndEnv(t *testing.T) {
+	client := dial(t, sshserver.New(sshserver.Config{}))
+	s, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.RequestPty("xterm-test", 30, 100, nil); err != nil {
+		t.Fatalf("pty-req: %v", err)
+	}
+	if err := s.Setenv("LANG", "C.UTF-8"); err != nil {
+		t.Fatalf("env: %v", err)
+	}
+	if err := s.Setenv("LD_PRELOAD", "evil.so"); err == nil {
+		t.Fatalf("expected LD_PRELOAD to be rejected")
+	}
+
+	out, err := s.Output(`stty size; echo "$TERM $LANG"; exit 4`)
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 4 {
+		t.Fatalf("expected exit status 4, got %v", err)
+	}
+	if !strings.Contains(string(out), "30 100") || !strings.Contains(string(out), "xterm-test C.UTF-8") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestShellWindowChange(t *testing.T) {
+	client := dial(t, sshserver.New(sshserver.Config{
+		Shell: crosspty.CommandConfig{Argv: []string{"sh", "-c", "read line; stty size"}},
+	}))
+	s, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	defer s.Close()
+
+	stdin, _ := s.StdinPipe()
+	var out strings.Builder
+	s.Stdout = &out
+	if err := s.RequestPty("xterm", 24, 80, nil); err != nil {
+		t.Fatalf("pty-req: %v", err)
+	}
+	if err := s.Shell(); err != nil {
+		t.Fatalf("shell: %v", err)
+	}
+	if err := s.WindowChange(40, 120); err != nil {
+		t.Fatalf("window-change: %v", err)
+	}
+	// window-change has no reply; the next request is handled after it.
+	s.SendRequest("ping@test", true, nil)
+	stdin.Write([]byte("\r"))
+
+	if err := s.Wait(); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if !strings.Contains(out.String(), "40 120") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+func TestSignal(t *testing.T) {
+	client := dial(t, sshserver.New(sshserver.Config{}))
+	s, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	defer s.Close()
+
+	stdout, _ := s.StdoutPipe()
+	if err := s.Start("echo ready; exec sleep 100"); err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	buf := make([]byte, 64)
+	if n, _ := stdout.Read(buf); !strings.Contains(string(buf[:n]), "ready") {
+		t.Fatalf("expected ready, got %q", buf[:n])
+	}
+	if err := s.Signal(ssh.SIGTERM); err != nil {
+		t.Fatalf("signal: %v", err)
+	}
+
+	var exitErr *ssh.ExitError
+	if err := s.Wait(); !errors.As(err, &exitErr) || exitErr.Signal() != "TERM" {
+		t.Fatalf("expected exit-signal TERM, got %v", err)
+	}
+}
+
+func TestRejectsOtherChannels(t *testing.T) {
+	client := dial(t, sshserver.New(sshserver.Config{}))
+	if _, _, err := client.OpenChannel("direct-tcpip", nil); err == nil {
+		t.Fatalf("expected direct-tcpip to be rejected")
+	}
+}
+
+func TestExecStdinEOF(t *testing.T) {
+	client := dial(t, sshserver.New(sshserver.Config{}))
+	s, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	defer s.Close()
+
+	// No trailing newline: the partial line must be submitted, too.
+	s.Stdin = strings.NewReader("hello\nworld")
+	out, err := s.Output("tr a-z A-Z")
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if !strings.Contains(string(out), "HELLO") || !strings.Contains(string(out), "WORLD") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestExecWithoutPtyIsBinarySafe(t *testing.T) {
+	client := dial(t, sshserver.New(sshserver.Config{}))
+	s, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	defer s.Close()
+
+	// Every byte value, and a line longer than MAX_CANON.
+	var in []byte
+	for range 64 {
+		for b := range 256 {
+			in = append(in, byte(b))
+		}
+	}
+	in = append(in, bytes.Repeat([]byte("x"), 10000)...)
+	s.Stdin = bytes.NewReader(in)
+	out, err := s.Output("cat")
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if !bytes.Equal(out, in) {
+		t.Fatalf("output differs from input: got %d bytes, want %d", len(out), len(in))
+	}
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package sshserver
+
+import (
+	"github.com/Kodecable/crosspty"
+
+	"golang.org/x/sys/unix"
+)
+
+// binaryTermios returns the attributes for sessions without "pty-req": no
+// echo, signals or conversions. Canonical mode stays on, as only there the
+// EOF character ends the input, also of a read that is already blocked;
+// inputWriter escapes the other special characters.
+func binaryTermios() *crosspty.Termios {
+	t := crosspty.DefaultTermios().Raw()
+	t.Canonical = true // for the EOF character
+	t.Extended = true  // for the literal-next character
+	t.NLToCRNL = false
+	return &t
+}
+
+// specialChars returns the EOF and literal-next characters of p, or 0 if
+// unknown.
+func specialChars(p crosspty.Pty) (eof, lnext byte) {
+	pu, ok := p.(crosspty.PtyUnix)
+	if !ok {
+		return 0, 0
+	}
+	t, err := pu.RawTermios()
+	if err != nil {
+		return 0, 0
+	}
+	return t.Cc[unix.VEOF], t.Cc[unix.VLNEXT]
+}
@@ -0,0 +1,13 @@
+package sshserver
+
+import "github.com/Kodecable/crosspty"
+
+// binaryTermios returns nil, ConPTY has no terminal attributes.
+func binaryTermios() *crosspty.Termios {
+	return nil
+}
+
+// specialChars returns 0: there is no EOF character for the console.
+func specialChars(p crosspty.Pty) (eof, lnext byte) {
+	return 0, 0
+}
@@ -0,0 +1,115 @@
+//go:build linux
+
+package crosspty
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// subreaper tracks the sessions whose orphans are reaped.
+var subreaper struct {
+	once sync.Once
+	err  error
+
+	mu       sync.Mutex
+	enabled  bool
+	sessions map[int]bool // session ID -> closed
+}
+
+// Linux only.
+// EnableChildSubreaper makes the current process a child subreaper (see
+// PR_SET_CHILD_SUBREAPER in prctl(2)): descendants of subprocesses started
+// afterwards are reparented to it instead of init when their parent exits,
+// and a background goroutine reaps them once they exit. This avoids zombies
+// in containers without an init process that reaps, e.g. after a group kill.
+//
+// Only processes in the session of a subprocess started by this package are
+// reaped, so the exit status of processes started by other means (e.g.
+// os/exec) is not stolen. The subprocesses themselves are still waited for
+// by their Pty. Processes that called setsid() are reparented to the current
+// process too, but not reaped.
+//
+// It affects the whole process and can not be disabled. It is safe to call
+// more than once.
+func EnableChildSubreaper() error {
+	subreaper.once.Do(func() {
+		if subreaper.err = unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); subreaper.err != nil {
+			return
+		}
+		subreaper.mu.Lock()
+		subreaper.enabled = true
+		subreaper.sessions = map[int]bool{}
+		subreaper.mu.Unlock()
+
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGCHLD)
+		go func() {
+			for range ch {
+				reapOrphans()
+			}
+		}()
+	})
+	return subreaper.err
+}
+
+// registerSession starts reaping orphans in the session of a new subprocess.
+func registerSession(sid int) {
+	subreaper.mu.Lock()
+	defer subreaper.mu.Unlock()
+	if subreaper.enabled {
+		subreaper.sessions[sid] = false
+	}
+}
+
+// releaseSession stops reaping orphans in the session once none is left.
+func releaseSession(sid int) {
+	subreaper.mu.Lock()
+	if _, ok := subreaper.sessions[sid]; ok {
+		subreaper.sessions[sid] = true
+	}
+	subreaper.mu.Unlock()
+	reapOrphans()
+}
+
+// reapOrphans reaps exited children in the registered sessions, except the
+// session leaders, which are the subprocesses waited for by their Pty.
+func reapOrphans() {
+	subreaper.mu.Lock()
+	defer subreaper.mu.Unlock()
+	if len(subreaper.sessions) == 0 {
+		return
+	}
+
+	pids, err := listPIDs()
+	if err != nil {
+		return
+	}
+	self := os.Getpid()
+	alive := map[int]bool{}
+	for _, pid := range pids {
+		st, ok := readProcStat(pid)
+		if !ok {
+			continue
+		}
+		if _, registered := subreaper.sessions[st.session]; !registered {
+			continue
+		}
+		if st.state == 'Z' && st.ppid == self && pid != st.session {
+			var ws unix.WaitStatus
+			unix.Wait4(pid, &ws, unix.WNOHANG, nil)
+			continue
+		}
+		alive[st.session] = true
+	}
+
+	for sid, closed := range subreaper.sessions {
+		if closed && !alive[sid] {
+			delete(subreaper.sessions, sid)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+// Package telnet serves processes running on a crosspty.Pty over telnet.
+//
+//	srv := telnet.New(telnet.Config{
+//		Command: crosspty.CommandConfig{Argv: []string{"login"}},
+//	})
+//	l, _ := net.Listen("tcp", ":23")
+//	srv.Serve(l)
+//
+// On connect, the server offers ECHO, SUPPRESS-GO-AHEAD and BINARY, and asks
+// for NAWS (RFC 1073) and TERMINAL-TYPE (RFC 1091). The process is started
+// once the client answered (or after Config.NegotiationTimeout), with the
+// window size as CommandConfig.Size and the terminal type as TERM. Later
+// NAWS updates resize the Pty.
+//
+// Telnet provides no encryption or authentication, use it on trusted
+// networks only.
+package telnet
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+)
+
+// Telnet commands (RFC 854).
+const (
+	cmdSE   = 240
+	cmdSB   = 250
+	cmdWILL = 251
+	cmdWONT = 252
+	cmdDO   = 253
+	cmdDONT = 254
+	cmdIAC  = 255
+)
+
+// Telnet options.
+const (
+	optBinary = 0
+	optEcho   = 1
+	optSGA    = 3
+	optTTYPE  = 24
+	optNAWS   = 31
+)
+
+// TERMINAL-TYPE subnegotiation commands.
+const (
+	ttypeIs   = 0
+	ttypeSend = 1
+)
+
+// Options the server enables on its side, and asks the client to enable.
+var (
+	localOptions  = []byte{optEcho, optSGA, optBinary}
+	remoteOptions = []byte{optSGA, optBinary, optTTYPE, optNAWS}
+)
+
+type Config struct {
+	// The process to start for each connection. Size and TERM are taken from
+	// the negotiation if the client supports NAWS and TERMINAL-TYPE.
+	Command crosspty.CommandConfig
+
+	// How long to wait for the client to answer NAWS and TERMINAL-TYPE
+	// before starting the process anyway.
+	// default: 2s
+	NegotiationTimeout time.Duration
+}
+
+type Server struct {
+	cfg Config
+}
+
+func New(cfg Config) *Server {
+	if cfg.NegotiationTimeout <= 0 {
+		cfg.NegotiationTimeout = 2 * time.Second
+	}
+	return &Server{cfg: cfg}
+}
+
+// Serve accepts connections on l and serves each in its own goroutine,
+// until Accept returns an error.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+// ServeConn serves c until the process output ends or the client
+// disconnects, then closes both.
+func (s *Server) ServeConn(nc net.Conn) error {
+	defer nc.Close()
+
+	c := &conn{nc: nc, ready: make(chan struct{})}
+	var neg []byte
+	for _, opt := range localOptions {
+		c.localReq[opt] = true
+		neg = append(neg, cmdIAC, cmdWILL, opt)
+	}
+	for _, opt := range remoteOptions {
+		c.remoteReq[opt] = true
+		neg = append(neg, cmdIAC, cmdDO, opt)
+	}
+	if err := c.send(neg...); err != nil {
+		return err
+	}
+
+	inputDone := make(chan error, 1)
+	go func() {
+		inputDone <- c.input()
+	}()
+
+	timer := time.NewTimer(s.cfg.NegotiationTimeout)
+	select {
+	case <-c.ready:
+	case <-timer.C:
+	case err := <-inputDone:
+		timer.Stop()
+		return err
+	}
+	timer.Stop()
+
+	cc := s.cfg.Command
+	cc.EnvInject = maps.Clone(cc.EnvInject) // TERM is added below
+	c.mu.Lock()
+	if c.size.Rows != 0 && c.size.Cols != 0 {
+		cc.Size = c.size
+	}
+	if c.term != "" {
+		if cc.EnvInject == nil {
+			cc.EnvInject = map[string]string{}
+		}
+		cc.EnvInject["TERM"] = c.term
+	}
+	c.mu.Unlock()
+
+	p, err := crosspty.Start(cc)
+	if err != nil {
+		c.send([]byte("\r\nunable to start: " + err.Error() + "\r\n")...)
+		return err
+	}
+	defer p.Close()
+
+	// Input that arrived during the negotiation goes first. The input
+	// goroutine writes to the Pty itself from now on.
+	c.mu.Lock()
+	if len(c.pending) != 0 {
+		p.Write(c.pending)
+		c.pending = nil
+	}
+	c.pty = p
+	c.mu.Unlock()
+
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := p.Read(buf)
+			if n > 0 && c.send(escapeIAC(buf[:n])...) != nil {
+				break
+			}
+			if err != nil {
+				break
+			}
+		}
+		nc.Close()
+	}()
+
+	err = <-inputDone
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return err
+}
+
+func escapeIAC(d []byte) []byte {
+	return bytes.ReplaceAll(d, []byte{cmdIAC}, []byte{cmdIAC, cmdIAC})
+}
+
+// parser states
+const (
+	stData = iota
+	stIAC
+	stOption // after WILL, WONT, DO or DONT
+	stSB
+	stSBIAC
+)
+
+type conn struct {
+	nc  net.Conn
+	wmu sync.Mutex
+
+	// Only used by the input goroutine.
+	state   int
+	verb    byte
+	sbuf    []byte
+	inBuf   []byte
+	skipEOL bool // a CR was received, drop a following LF or NUL
+
+	local, remote       [256]bool // enabled options
+	localReq, remoteReq [256]bool // sent WILL or DO, waiting for the answer
+
+	mu        sync.Mutex
+	pty       crosspty.Pty
+	pending   []byte // input before pty is set
+	size      crosspty.TermSize
+	term      string
+	gotNAWS   bool
+	gotTTYPE  bool
+	readyOnce sync.Once
+	ready     chan struct{} // closed when NAWS and TTYPE are settled
+}
+
+func (c *conn) send(d ...byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.nc.Write(d)
+	return err
+}
+
+// input parses the client data until the connection fails.
+func (c *conn) input() error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.nc.Read(buf)
+		if n > 0 {
+			c.inBuf = c.inBuf[:0]
+			for _, b := range buf[:n] {
+				c.parse(b)
+			}
+			if len(c.inBuf) != 0 {
+				if werr := c.deliver(c.inBuf); werr != nil {
+					return werr
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *conn) deliver(d []byte) error {
+	c.mu.Lock()
+	p := c.pty
+	if p == nil {
+		c.pending = append(c.pending, d...)
+	}
+	c.mu.Unlock()
+
+	if p != nil {
+		_, err := p.Write(d)
+		return err
+	}
+	return nil
+}
+
+func (c *conn) parse(b byte) {
+	switch c.state {
+	case stData:
+		if b == cmdIAC {
+			c.state = stIAC
+			return
+		}
+		if c.skipEOL {
+			c.skipEOL = false
+			if b == '\n' || b == 0 {
+				return
+			}
+		}
+		// An NVT sends CR LF or CR NUL for Enter, the PTY wants CR.
+		if b == '\r' && !c.remote[optBinary] {
+			c.skipEOL = true
+		}
+		c.inBuf = append(c.inBuf, b)
+	case stIAC:
+		switch b {
+		case cmdIAC:
+			c.inBuf = append(c.inBuf, cmdIAC)
+			c.state = stData
+		case cmdWILL, cmdWONT, cmdDO, cmdDONT:
+			c.verb = b
+			c.state = stOption
+		case cmdSB:
+			c.sbuf = c.sbuf[:0]
+			c.state = stSB
+		default:
+			// NOP, GA, BRK, AYT, ... are ignored.
+			c.state = stData
+		}
+	case stOption:
+		c.negotiate(c.verb, b)
+		c.state = stData
+	case stSB:
+		if b == cmdIAC {
+			c.state = stSBIAC
+			return
+		}
+		if len(c.sbuf) < 1024 {
+			c.sbuf = append(c.sbuf, b)
+		}
+	case stSBIAC:
+		switch b {
+		case cmdIAC:
+			c.sbuf = append(c.sbuf, cmdIAC)
+			c.state = stSB
+		case cmdSE:
+			c.subnegotiation(c.sbuf)
+			c.state = stData
+		default:
+			// Broken subnegotiation, give up on it.
+			c.state = stData
+		}
+	}
+}
+
+func supported(opts []byte, opt byte) bool {
+	return bytes.IndexByte(opts, opt) >= 0
+}
+
+// negotiate handles WILL, WONT, DO and DONT without negotiation loops
+// (RFC 854, RFC 1143): an option already in the requested state is never
+// acknowledged again.
+func (c *conn) negotiate(verb, opt byte) {
+	switch verb {
+	case cmdDO:
+		switch {
+		case !supported(localOptions, opt):
+			c.send(cmdIAC, cmdWONT, opt)
+		case !c.local[opt]:
+			c.local[opt] = true
+			if !c.localReq[opt] {
+				c.send(cmdIAC, cmdWILL, opt)
+			}
+		}
+		c.localReq[opt] = false
+	case cmdDONT:
+		if c.local[opt] {
+			c.local[opt] = false
+			c.send(cmdIAC, cmdWONT, opt)
+		}
+		c.localReq[opt] = false
+	case cmdWILL:
+		switch {
+		case !supported(remoteOptions, opt):
+			c.send(cmdIAC, cmdDONT, opt)
+		case !c.remote[opt]:
+			c.remote[opt] = true
+			if !c.remoteReq[opt] {
+				c.send(cmdIAC, cmdDO, opt)
+			}
+			if opt == optTTYPE {
+				c.send(cmdIAC, cmdSB, optTTYPE, ttypeSend, cmdIAC, cmdSE)
+			}
+		}
+		c.remoteReq[opt] = false
+	case cmdWONT:
+		if c.remote[opt] {
+			c.remote[opt] = false
+			c.send(cmdIAC, cmdDONT, opt)
+		}
+		c.remoteReq[opt] = false
+		switch opt {
+		case optTTYPE:
+			c.settle(&c.gotTTYPE)
+		case optNAWS:
+			c.settle(&c.gotNAWS)
+		}
+	}
+}
+
+func (c *conn) subnegotiation(d []byte) {
+	if len(d) == 0 {
+		return
+	}
+	switch d[0] {
+	case optNAWS:
+		if len(d) != 5 {
+			return
+		}
+		size := crosspty.TermSize{
+			Cols: uint16(d[1])<<8 | uint16(d[2]),
+			Rows: uint16(d[3])<<8 | uint16(d[4]),
+		}
+		if size.Rows == 0 || size.Cols == 0 {
+			return
+		}
+		c.mu.Lock()
+		c.size = size
+		if c.pty != nil {
+			c.pty.Resize(size)
+		}
+		c.mu.Unlock()
+		c.settle(&c.gotNAWS)
+	case optTTYPE:
+		if len(d) < 2 || d[1] != ttypeIs {
+			return
+		}
+		c.mu.Lock()
+		// Terminal types are sent in upper case, terminfo names are lower
+		// case.
+		c.term = strings.ToLower(string(d[2:]))
+		c.mu.Unlock()
+		c.settle(&c.gotTTYPE)
+	}
+}
+
+// settle marks a negotiation as done and closes ready when all are.
+func (c *conn) settle(flag *bool) {
+	c.mu.Lock()
+	*flag = true
+	done := c.gotNAWS && c.gotTTYPE
+	c.mu.Unlock()
+	if done {
+		c.readyOnce.Do(func() { close(c.ready) })
+	}
+}
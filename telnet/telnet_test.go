@@ -0,0 +1,191 @@
+package telnet_test
+
+import (
+	"bytes"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/telnet"
+)
+
+const (
+	se   = 240
+	sb   = 250
+	will = 251
+	wont = 252
+	do   = 253
+	dont = 254
+	iac  = 255
+
+	ttype = 24
+	naws  = 31
+)
+
+func skipWindows(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+}
+
+func serve(t *testing.T, cfg telnet.Config) net.Conn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go telnet.New(cfg).Serve(l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	return c
+}
+
+// client is a minimal telnet client. It agrees to NAWS and TERMINAL-TYPE,
+// refuses other options, and collects data with IAC IAC unescaped.
+type client struct {
+	t    *testing.T
+	c    net.Conn
+	term string
+	naws []byte
+	data bytes.Buffer
+}
+
+func (cl *client) send(d ...byte) {
+	if _, err := cl.c.Write(d); err != nil {
+		cl.t.Fatalf("write: %v", err)
+	}
+}
+
+// readUntil processes the connection until the data contains s.
+func (cl *client) readUntil(s string) string {
+	cl.t.Helper()
+	buf := make([]byte, 1)
+	next := func() byte {
+		if _, err := cl.c.Read(buf); err != nil {
+			cl.t.Fatalf("read %q: %v (got %q)", s, err, cl.data.String())
+		}
+		return buf[0]
+	}
+	for !strings.Contains(cl.data.String(), s) {
+		b := next()
+		if b != iac {
+			cl.data.WriteByte(b)
+			continue
+		}
+		switch cmd := next(); cmd {
+		case iac:
+			cl.data.WriteByte(iac)
+		case do:
+			opt := next()
+			switch opt {
+			case naws:
+				cl.send(iac, will, naws)
+				cl.send(append(append([]byte{iac, sb, naws}, cl.naws...), iac, se)...)
+			case ttype:
+				cl.send(iac, will, ttype)
+			default:
+				cl.send(iac, wont, opt)
+			}
+		case will:
+			cl.send(iac, dont, next())
+		case wont, dont:
+			next()
+		case sb:
+			var sub []byte
+			for {
+				b := next()
+				if b == iac && next() == se {
+					break
+				}
+				sub = append(sub, b)
+			}
+			if bytes.Equal(sub, []byte{ttype, 1}) {
+				cl.send(append(append([]byte{iac, sb, ttype, 0}, cl.term...), iac, se)...)
+			}
+		}
+	}
+	return cl.data.String()
+}
+
+func TestTelnetNegotiation(t *testing.T) {
+	skipWindows(t)
+	c := serve(t, telnet.Config{
+		Command: crosspty.CommandConfig{
+			Argv: []string{"sh", "-c", "echo term=$TERM; stty size; read line; stty size; echo got $line"},
+		},
+	})
+	cl := &client{t: t, c: c, term: "XTERM-TEST", naws: []byte{0, 100, 0, 30}}
+
+	cl.readUntil("term=xterm-test")
+	cl.readUntil("30 100")
+
+	cl.send(iac, sb, naws, 0, 120, 0, 40, iac, se)
+	cl.send([]byte("hello\r\n")...)
+	out := cl.readUntil("got hello")
+	if !strings.Contains(out, "40 120") {
+		t.Fatalf("expected the NAWS update to resize, got %q", out)
+	}
+}
+
+func TestTelnetNoNegotiation(t *testing.T) {
+	skipWindows(t)
+	c := serve(t, telnet.Config{
+		Command:            crosspty.CommandConfig{Argv: []string{"sh", "-c", "echo term=$TERM."}},
+		NegotiationTimeout: 100 * time.Millisecond,
+	})
+
+	// A client that never answers still gets the process.
+	var out []byte
+	buf := make([]byte, 1024)
+	for !bytes.Contains(out, []byte("term=")) {
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v (got %q)", err, out)
+		}
+		out = append(out, buf[:n]...)
+	}
+}
+
+func TestTelnetIACEscaping(t *testing.T) {
+	skipWindows(t)
+	c := serve(t, telnet.Config{
+		Command: crosspty.CommandConfig{
+			Argv: []string{"sh", "-c", `stty raw -echo; printf 'X\377Y\n'; head -c 3 | od -An -tx1`},
+		},
+	})
+	cl := &client{t: t, c: c, term: "xterm", naws: []byte{0, 80, 0, 24}}
+
+	if out := cl.readUntil("Y"); !strings.Contains(out, "X\xffY") {
+		t.Fatalf("expected an unescaped 0xFF in the output, got %q", out)
+	}
+	cl.send('a', iac, iac, 'b')
+	cl.readUntil("61 ff 62")
+}
+
+func TestTelnetClosesOnExit(t *testing.T) {
+	skipWindows(t)
+	c := serve(t, telnet.Config{
+		Command: crosspty.CommandConfig{Argv: []string{"sh", "-c", "echo bye"}},
+	})
+	cl := &client{t: t, c: c, term: "xterm", naws: []byte{0, 80, 0, 24}}
+	cl.readUntil("bye")
+
+	buf := make([]byte, 1024)
+	for {
+		if _, err := c.Read(buf); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatalf("connection was not closed")
+			}
+			return
+		}
+	}
+}
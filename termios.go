@@ -0,0 +1,70 @@
+package crosspty
+
+// Termios holds the commonly used terminal attributes of a PTY, see
+// termios(3). The field comments name the corresponding flags.
+//
+// Use CommandConfig.Termios to set them before the subprocess starts. On
+// Unix, use PtyUnix to get and set them on a running Pty, or
+// RawTermios/SetRawTermios for the attributes not covered here. Setting an
+// attribute the OS does not support (e.g. UTF8 on FreeBSD) returns an error
+// wrapping ErrTermiosNotSupported.
+type Termios struct {
+	// Local modes.
+	Echo      bool // ECHO: echo input characters
+	Canonical bool // ICANON: line editing, input is available line by line
+	Signals   bool // ISIG: Intr, Quit and Susp generate signals
+	Extended  bool // IEXTEN: implementation-defined input processing
+
+	// Input modes.
+	CRToNL      bool // ICRNL: translate CR to NL on input
+	FlowControl bool // IXON: Ctrl-S/Ctrl-Q flow control on output
+	UTF8        bool // IUTF8: UTF-8 aware line editing, Linux and Darwin only
+
+	// Output modes.
+	OutputProcessing bool // OPOST: enable output processing
+	NLToCRNL         bool // ONLCR: translate NL to CR-NL on output
+
+	// Special characters, e.g. 0x03 (Ctrl-C) for Intr. 0 disables the
+	// character.
+	Intr  byte // VINTR
+	Quit  byte // VQUIT
+	Erase byte // VERASE
+	Kill  byte // VKILL
+	EOF   byte // VEOF
+	Susp  byte // VSUSP
+}
+
+// DefaultTermios returns the usual attributes of a new terminal: cooked mode
+// with echo, and ^C, ^\, DEL, ^U, ^D and ^Z as special characters.
+func DefaultTermios() Termios {
+	return Termios{
+		Echo:             true,
+		Canonical:        true,
+		Signals:          true,
+		Extended:         true,
+		CRToNL:           true,
+		FlowControl:      true,
+		OutputProcessing: true,
+		NLToCRNL:         true,
+		Intr:             0x03,
+		Quit:             0x1c,
+		Erase:            0x7f,
+		Kill:             0x15,
+		EOF:              0x04,
+		Susp:             0x1a,
+	}
+}
+
+// Raw returns a copy of t with the flags cleared that cfmakeraw(3) clears,
+// among those covered by Termios. The other flags cleared by cfmakeraw(3)
+// are already off on a new PTY.
+func (t Termios) Raw() Termios {
+	t.Echo = false
+	t.Canonical = false
+	t.Signals = false
+	t.Extended = false
+	t.CRToNL = false
+	t.FlowControl = false
+	t.OutputProcessing = false
+	return t
+}
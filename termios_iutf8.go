@@ -0,0 +1,7 @@
+//go:build linux || darwin
+
+package crosspty
+
+import "golang.org/x/sys/unix"
+
+const termiosIUTF8 = unix.IUTF8
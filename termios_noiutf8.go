@@ -0,0 +1,7 @@
+//go:build unix && !(linux || darwin)
+
+package crosspty
+
+// IUTF8 is not available, setting Termios.UTF8 returns
+// ErrTermiosNotSupported.
+const termiosIUTF8 = 0
@@ -0,0 +1,12 @@
+//go:build unix && !(darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package crosspty
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+
+	posixVDisable = 0
+)
@@ -0,0 +1,119 @@
+//go:build unix
+
+package crosspty
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func (p *ptyUnix) Termios() (Termios, error) {
+	raw, err := p.RawTermios()
+	if err != nil {
+		return Termios{}, err
+	}
+	return termiosFromUnix(raw), nil
+}
+
+func (p *ptyUnix) SetTermios(t Termios) error {
+	return p.control(func(fd int) error {
+		return setTermios(fd, t)
+	})
+}
+
+func (p *ptyUnix) RawTermios() (t *unix.Termios, err error) {
+	err = p.control(func(fd int) error {
+		t, err = unix.IoctlGetTermios(fd, ioctlGetTermios)
+		return err
+	})
+	return
+}
+
+func (p *ptyUnix) SetRawTermios(t *unix.Termios) error {
+	return p.control(func(fd int) error {
+		return unix.IoctlSetTermios(fd, ioctlSetTermios, t)
+	})
+}
+
+// setTermios sets t on the terminal fd, and keeps the attributes Termios
+// does not cover.
+func setTermios(fd int, t Termios) error {
+	raw, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return err
+	}
+	if err := termiosToUnix(t, raw); err != nil {
+		return err
+	}
+	return unix.IoctlSetTermios(fd, ioctlSetTermios, raw)
+}
+
+func termiosFromUnix(raw *unix.Termios) Termios {
+	cc := func(i int) byte {
+		if raw.Cc[i] == posixVDisable {
+			return 0
+		}
+		return raw.Cc[i]
+	}
+	return Termios{
+		Echo:             hasFlag(raw.Lflag, unix.ECHO),
+		Canonical:        hasFlag(raw.Lflag, unix.ICANON),
+		Signals:          hasFlag(raw.Lflag, unix.ISIG),
+		Extended:         hasFlag(raw.Lflag, unix.IEXTEN),
+		CRToNL:           hasFlag(raw.Iflag, unix.ICRNL),
+		FlowControl:      hasFlag(raw.Iflag, unix.IXON),
+		UTF8:             hasFlag(raw.Iflag, termiosIUTF8),
+		OutputProcessing: hasFlag(raw.Oflag, unix.OPOST),
+		NLToCRNL:         hasFlag(raw.Oflag, unix.ONLCR),
+		Intr:             cc(unix.VINTR),
+		Quit:             cc(unix.VQUIT),
+		Erase:            cc(unix.VERASE),
+		Kill:             cc(unix.VKILL),
+		EOF:              cc(unix.VEOF),
+		Susp:             cc(unix.VSUSP),
+	}
+}
+
+func termiosToUnix(t Termios, raw *unix.Termios) error {
+	if t.UTF8 && termiosIUTF8 == 0 {
+		return fmt.Errorf("%w: IUTF8", ErrTermiosNotSupported)
+	}
+
+	setFlag(&raw.Lflag, unix.ECHO, t.Echo)
+	setFlag(&raw.Lflag, unix.ICANON, t.Canonical)
+	setFlag(&raw.Lflag, unix.ISIG, t.Signals)
+	setFlag(&raw.Lflag, unix.IEXTEN, t.Extended)
+	setFlag(&raw.Iflag, unix.ICRNL, t.CRToNL)
+	setFlag(&raw.Iflag, unix.IXON, t.FlowControl)
+	setFlag(&raw.Iflag, termiosIUTF8, t.UTF8)
+	setFlag(&raw.Oflag, unix.OPOST, t.OutputProcessing)
+	setFlag(&raw.Oflag, unix.ONLCR, t.NLToCRNL)
+
+	cc := func(i int, c byte) {
+		if c == 0 {
+			c = posixVDisable
+		}
+		raw.Cc[i] = c
+	}
+	cc(unix.VINTR, t.Intr)
+	cc(unix.VQUIT, t.Quit)
+	cc(unix.VERASE, t.Erase)
+	cc(unix.VKILL, t.Kill)
+	cc(unix.VEOF, t.EOF)
+	cc(unix.VSUSP, t.Susp)
+	return nil
+}
+
+// The flag fields of unix.Termios are uint32 or uint64 depending on the OS.
+func hasFlag[T ~uint32 | ~uint64](f T, mask T) bool {
+	return mask != 0 && f&mask == mask
+}
+
+func setFlag[T ~uint32 | ~uint64](f *T, mask T, on bool) {
+	if on {
+		*f |= mask
+	} else {
+		*f &^= mask
+	}
+}
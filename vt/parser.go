@@ -0,0 +1,618 @@
+package vt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type parserState uint8
+
+const (
+	stateGround parserState = iota
+	stateEscape
+	stateEscapeIntermediate
+	stateEscapeIgnore
+	stateCSI
+	stateCSIIgnore
+	stateOSC
+	stateString // DCS, SOS, PM and APC strings are ignored
+	stateStringEsc
+)
+
+// Sequences longer than these limits are ignored.
+const (
+	maxParamBytes        = 256
+	maxIntermediateBytes = 2 // no sequence uses more
+	maxOSCBytes          = 4096
+)
+
+// parser is a state machine modeled after the VT500 parser described at
+// https://vt100.net/emu/dec_ansi_parser, fed with UTF-8 text.
+type parser struct {
+	t     *Terminal
+	state parserState
+
+	utf8Buf [utf8.UTFMax]byte
+	utf8Len int
+
+	private       byte
+	params        []byte
+	intermediates []byte
+	osc           []byte
+	oscEsc        bool
+
+	replies []byte
+}
+
+func (p *parser) takeReplies() []byte {
+	r := p.replies
+	p.replies = nil
+	return r
+}
+
+func (p *parser) feed(d []byte) {
+	for _, b := range d {
+		p.feedByte(b)
+	}
+}
+
+func (p *parser) feedByte(b byte) {
+	switch p.state {
+	case stateOSC:
+		p.feedOSC(b)
+		return
+	case stateString:
+		if b == 0x1B {
+			p.state = stateStringEsc
+		} else if b == 0x07 {
+			p.state = stateGround
+		}
+		return
+	case stateStringEsc:
+		if b == '\\' {
+			p.state = stateGround
+		} else {
+			p.state = stateString
+		}
+		return
+	}
+
+	// C0 controls are executed in every other state, even in the middle of
+	// an escape sequence.
+	if b < 0x20 {
+		switch b {
+		case 0x18, 0x1A: // CAN, SUB
+			p.state = stateGround
+		case 0x1B:
+			p.utf8Len = 0
+			p.state = stateEscape
+			p.intermediates = p.intermediates[:0]
+		default:
+			p.execute(b)
+		}
+		return
+	}
+	if b == 0x7F { // DEL
+		return
+	}
+
+	switch p.state {
+	case stateGround:
+		p.feedText(b)
+
+	case stateEscape:
+		switch {
+		case b == '[':
+			p.state = stateCSI
+			p.private = 0
+			p.params = p.params[:0]
+			p.intermediates = p.intermediates[:0]
+		case b == ']':
+			p.state = stateOSC
+			p.osc = p.osc[:0]
+			p.oscEsc = false
+		case b == 'P' || b == 'X' || b == '^' || b == '_':
+			p.state = stateString
+		case b >= 0x20 && b <= 0x2F:
+			p.intermediates = append(p.intermediates, b)
+			p.state = stateEscapeIntermediate
+		default:
+			p.state = stateGround
+			p.escDispatch(b)
+		}
+
+	case stateEscapeIntermediate:
+		switch {
+		case b >= 0x20 && b <= 0x2F && len(p.intermediates) >= maxIntermediateBytes:
+			p.state = stateEscapeIgnore
+		case b >= 0x20 && b <= 0x2F:
+			p.intermediates = append(p.intermediates, b)
+		default:
+			p.state = stateGround
+			p.escDispatch(b)
+		}
+
+	case stateEscapeIgnore:
+		if b < 0x20 || b > 0x2F {
+			p.state = stateGround
+		}
+
+	case stateCSI:
+		switch {
+		case b >= '0' && b <= ';':
+			if len(p.intermediates) != 0 || len(p.params) >= maxParamBytes {
+				p.state = stateCSIIgnore
+			} else {
+				p.params = append(p.params, b)
+			}
+		case b >= '<' && b <= '?':
+			if len(p.params) != 0 || p.private != 0 {
+				p.state = stateCSIIgnore
+			} else {
+				p.private = b
+			}
+		case b >= 0x20 && b <= 0x2F:
+			if len(p.intermediates) >= maxIntermediateBytes {
+				p.state = stateCSIIgnore
+			} else {
+				p.intermediates = append(p.intermediates, b)
+			}
+		case b >= 0x40 && b <= 0x7E:
+			p.state = stateGround
+			p.csiDispatch(b)
+		default:
+			p.state = stateCSIIgnore
+		}
+
+	case stateCSIIgnore:
+		if b >= 0x40 && b <= 0x7E {
+			p.state = stateGround
+		}
+	}
+}
+
+func (p *parser) feedText(b byte) {
+	if p.utf8Len == 0 && b < utf8.RuneSelf {
+		p.t.print(rune(b))
+		return
+	}
+
+	p.utf8Buf[p.utf8Len] = b
+	p.utf8Len++
+	if !utf8.FullRune(p.utf8Buf[:p.utf8Len]) {
+		return
+	}
+	r, size := utf8.DecodeRune(p.utf8Buf[:p.utf8Len])
+	rest := append([]byte(nil), p.utf8Buf[size:p.utf8Len]...)
+	p.utf8Len = 0
+	p.t.print(r)
+	// Bytes that did not belong to an invalid sequence start over.
+	for _, b := range rest {
+		p.feedText(b)
+	}
+}
+
+func (p *parser) feedOSC(b byte) {
+	switch {
+	case p.oscEsc:
+		p.oscEsc = false
+		if b == '\\' {
+			p.state = stateGround
+			p.oscDispatch()
+			return
+		}
+		// Not ST: the OSC string is aborted by ESC.
+		p.state = stateEscape
+		p.intermediates = p.intermediates[:0]
+		p.feedByte(b)
+	case b == 0x07:
+		p.state = stateGround
+		p.oscDispatch()
+	case b == 0x1B:
+		p.oscEsc = true
+	case b == 0x18 || b == 0x1A:
+		p.state = stateGround
+	default:
+		if len(p.osc) < maxOSCBytes {
+			p.osc = append(p.osc, b)
+		}
+	}
+}
+
+func (p *parser) execute(b byte) {
+	t := p.t
+	c := &t.cur.cursor
+	switch b {
+	case '\b':
+		if c.pendingWrap {
+			c.pendingWrap = false
+		} else if c.col > 0 {
+			c.col--
+		}
+	case '\t':
+		t.tab(1)
+	case '\n', '\v', '\f':
+		c.pendingWrap = false
+		t.index()
+	case '\r':
+		c.col = 0
+		c.pendingWrap = false
+	case 0x0E: // SO
+		c.gl = 1
+	case 0x0F: // SI
+		c.gl = 0
+	}
+}
+
+func (p *parser) escDispatch(b byte) {
+	t := p.t
+	c := &t.cur.cursor
+
+	if len(p.intermediates) != 0 {
+		switch p.intermediates[0] {
+		case '(', ')':
+			g := 0
+			if p.intermediates[0] == ')' {
+				g = 1
+			}
+			if b == '0' {
+				c.charsets[g] = charsetDECGraphics
+			} else {
+				c.charsets[g] = charsetASCII
+			}
+		case '#':
+			if b == '8' { // DECALN
+				for r := range t.cur.lines {
+					for i := range t.cur.lines[r] {
+						t.cur.lines[r][i] = Cell{Rune: 'E', Width: 1}
+					}
+				}
+			}
+		}
+		return
+	}
+
+	switch b {
+	case '7': // DECSC
+		t.saveCursor()
+	case '8': // DECRC
+		t.restoreCursor()
+	case 'D': // IND
+		c.pendingWrap = false
+		t.index()
+	case 'E': // NEL
+		c.col = 0
+		c.pendingWrap = false
+		t.index()
+	case 'H': // HTS
+		t.tabs[c.col] = true
+	case 'M': // RI
+		t.reverseIndex()
+	case 'c': // RIS
+		t.reset(t.rows, t.cols)
+	}
+}
+
+// csiParams splits parameters into groups separated by ';'. Sub-parameters
+// separated by ':' stay in the same group. Missing values are -1.
+func (p *parser) csiParams() [][]int {
+	if len(p.params) == 0 {
+		return nil
+	}
+	var out [][]int
+	for _, group := range strings.Split(string(p.params), ";") {
+		var g []int
+		for _, s := range strings.Split(group, ":") {
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				v = -1
+			}
+			g = append(g, min(v, 65535))
+		}
+		out = append(out, g)
+	}
+	return out
+}
+
+func (p *parser) csiDispatch(final byte) {
+	t := p.t
+	c := &t.cur.cursor
+	params := p.csiParams()
+
+	// param returns the i-th parameter, or def if it is missing or 0 when
+	// 0 is not meaningful.
+	param := func(i, def int) int {
+		if i >= len(params) || params[i][0] <= 0 {
+			return def
+		}
+		return params[i][0]
+	}
+
+	if len(p.intermediates) != 0 {
+		// e.g. DECSCUSR (CSI Ps SP q), not relevant to screen content.
+		return
+	}
+
+	switch p.private {
+	case 0:
+	case '?':
+		switch final {
+		case 'h', 'l':
+			for i := range params {
+				p.setPrivateMode(params[i][0], final == 'h')
+			}
+		}
+		return
+	case '>':
+		if final == 'c' && param(0, 0) == 0 { // Secondary DA
+			p.replies = append(p.replies, "\x1b[>0;0;0c"...)
+		}
+		return
+	default:
+		return
+	}
+
+	switch final {
+	case '@': // ICH
+		t.insertChars(param(0, 1))
+	case 'A': // CUU
+		t.moveCursor(-param(0, 1), 0)
+	case 'B', 'e': // CUD, VPR
+		t.moveCursor(param(0, 1), 0)
+	case 'C', 'a': // CUF, HPR
+		t.moveCursor(0, param(0, 1))
+	case 'D': // CUB
+		t.moveCursor(0, -param(0, 1))
+	case 'E': // CNL
+		t.moveCursor(param(0, 1), 0)
+		c.col = 0
+	case 'F': // CPL
+		t.moveCursor(-param(0, 1), 0)
+		c.col = 0
+	case 'G', '`': // CHA, HPA
+		c.col = min(param(0, 1), t.cols) - 1
+		c.pendingWrap = false
+	case 'H', 'f': // CUP, HVP
+		t.setCursor(param(0, 1)-1, param(1, 1)-1)
+	case 'I': // CHT
+		t.tab(param(0, 1))
+	case 'J': // ED
+		t.eraseInDisplay(max(param(0, 0), 0))
+	case 'K': // EL
+		t.eraseInLine(max(param(0, 0), 0))
+	case 'L': // IL
+		t.insertLines(param(0, 1))
+	case 'M': // DL
+		t.deleteLines(param(0, 1))
+	case 'P': // DCH
+		t.deleteChars(param(0, 1))
+	case 'S': // SU
+		t.scrollUp(param(0, 1))
+	case 'T': // SD
+		if len(params) <= 1 { // more parameters means mouse tracking
+			t.scrollDown(param(0, 1))
+		}
+	case 'X': // ECH
+		t.eraseCells(c.row, c.col, c.col+param(0, 1))
+		c.pendingWrap = false
+	case 'Z': // CBT
+		t.backTab(param(0, 1))
+	case 'b': // REP is not supported, it needs the last printed character.
+	case 'c': // DA
+		if param(0, 0) == 0 {
+			p.replies = append(p.replies, "\x1b[?1;2c"...)
+		}
+	case 'd': // VPA
+		t.setCursor(param(0, 1)-1, c.col)
+	case 'g': // TBC
+		switch param(0, 0) {
+		case 0:
+			t.tabs[c.col] = false
+		case 3:
+			clear(t.tabs)
+		}
+	case 'h', 'l': // SM, RM
+		for i := range params {
+			if params[i][0] == 4 { // IRM
+				t.insert = final == 'h'
+			}
+		}
+	case 'm': // SGR
+		p.sgr(params)
+	case 'n': // DSR
+		switch param(0, 0) {
+		case 5:
+			p.replies = append(p.replies, "\x1b[0n"...)
+		case 6:
+			row := c.row
+			if c.origin {
+				row -= t.top
+			}
+			p.replies = fmt.Appendf(p.replies, "\x1b[%d;%dR", row+1, c.col+1)
+		}
+	case 'r': // DECSTBM
+		t.setScrollRegion(param(0, 1)-1, param(1, t.rows)-1)
+	case 's': // SCOSC
+		t.saveCursor()
+	case 'u': // SCORC
+		t.restoreCursor()
+	}
+}
+
+func (p *parser) setPrivateMode(mode int, set bool) {
+	t := p.t
+	switch mode {
+	case 6: // DECOM
+		t.cur.cursor.origin = set
+		t.setCursor(0, 0)
+	case 7: // DECAWM
+		t.autowrap = set
+		if !set {
+			t.cur.cursor.pendingWrap = false
+		}
+	case 25: // DECTCEM
+		t.cursorVisible = set
+	case 47, 1047:
+		if !set && t.cur == t.alt && mode == 1047 {
+			t.eraseInDisplay(2)
+		}
+		t.switchScreen(set)
+	case 1048:
+		if set {
+			t.saveCursor()
+		} else {
+			t.restoreCursor()
+		}
+	case 1049:
+		if set {
+			t.saveCursor()
+			t.switchScreen(true)
+		} else {
+			t.switchScreen(false)
+			t.restoreCursor()
+		}
+	}
+}
+
+func (p *parser) sgr(params [][]int) {
+	st := &p.t.cur.cursor.style
+	if len(params) == 0 {
+		*st = Style{}
+		return
+	}
+
+	for i := 0; i < len(params); i++ {
+		g := params[i]
+		switch v := max(g[0], 0); {
+		case v == 0:
+			*st = Style{}
+		case v == 1:
+			st.Attrs |= AttrBold
+		case v == 2:
+			st.Attrs |= AttrFaint
+		case v == 3:
+			st.Attrs |= AttrItalic
+		case v == 4:
+			if len(g) > 1 && g[1] == 0 { // 4:0 is "no underline"
+				st.Attrs &^= AttrUnderline
+			} else {
+				st.Attrs |= AttrUnderline
+			}
+		case v == 5 || v == 6:
+			st.Attrs |= AttrBlink
+		case v == 7:
+			st.Attrs |= AttrReverse
+		case v == 8:
+			st.Attrs |= AttrHidden
+		case v == 9:
+			st.Attrs |= AttrStrike
+		case v == 21:
+			st.Attrs |= AttrUnderline
+		case v == 22:
+			st.Attrs &^= AttrBold | AttrFaint
+		case v == 23:
+			st.Attrs &^= AttrItalic
+		case v == 24:
+			st.Attrs &^= AttrUnderline
+		case v == 25:
+			st.Attrs &^= AttrBlink
+		case v == 27:
+			st.Attrs &^= AttrReverse
+		case v == 28:
+			st.Attrs &^= AttrHidden
+		case v == 29:
+			st.Attrs &^= AttrStrike
+		case v >= 30 && v <= 37:
+			st.Fg = Color{Mode: ColorIndexed, Index: uint8(v - 30)}
+		case v == 38 || v == 48:
+			var color Color
+			var ok bool
+			if len(g) > 1 {
+				color, ok = extendedColor(g[1:], true)
+			} else {
+				var rest []int
+				for _, g := range params[i+1:] {
+					rest = append(rest, g[0])
+				}
+				var used int
+				color, ok, used = extendedColorSemicolon(rest)
+				i += used
+			}
+			if ok {
+				if v == 38 {
+					st.Fg = color
+				} else {
+					st.Bg = color
+				}
+			}
+		case v == 39:
+			st.Fg = Color{}
+		case v >= 40 && v <= 47:
+			st.Bg = Color{Mode: ColorIndexed, Index: uint8(v - 40)}
+		case v == 49:
+			st.Bg = Color{}
+		case v >= 90 && v <= 97:
+			st.Fg = Color{Mode: ColorIndexed, Index: uint8(v - 90 + 8)}
+		case v >= 100 && v <= 107:
+			st.Bg = Color{Mode: ColorIndexed, Index: uint8(v - 100 + 8)}
+		}
+	}
+}
+
+// extendedColor parses the colon form: 5:n, 2:r:g:b or 2:cs:r:g:b.
+func extendedColor(g []int, colon bool) (Color, bool) {
+	if len(g) == 0 {
+		return Color{}, false
+	}
+	switch g[0] {
+	case 5:
+		if len(g) >= 2 && g[1] >= 0 && g[1] <= 255 {
+			return Color{Mode: ColorIndexed, Index: uint8(g[1])}, true
+		}
+	case 2:
+		rgb := g[1:]
+		if colon && len(rgb) >= 4 {
+			rgb = rgb[1:] // skip the color space ID
+		}
+		if len(rgb) >= 3 {
+			return Color{Mode: ColorRGB, R: uint8(max(rgb[0], 0)), G: uint8(max(rgb[1], 0)), B: uint8(max(rgb[2], 0))}, true
+		}
+	}
+	return Color{}, false
+}
+
+// extendedColorSemicolon parses the legacy form: 38;5;n or 38;2;r;g;b. It
+// returns how many parameters were consumed.
+func extendedColorSemicolon(rest []int) (Color, bool, int) {
+	if len(rest) == 0 {
+		return Color{}, false, 0
+	}
+	switch rest[0] {
+	case 5:
+		if len(rest) < 2 {
+			return Color{}, false, len(rest)
+		}
+		c, ok := extendedColor(rest[:2], false)
+		return c, ok, 2
+	case 2:
+		if len(rest) < 4 {
+			return Color{}, false, len(rest)
+		}
+		c, ok := extendedColor(rest[:4], false)
+		return c, ok, 4
+	}
+	return Color{}, false, 1
+}
+
+func (p *parser) oscDispatch() {
+	cmd, text, ok := strings.Cut(string(p.osc), ";")
+	if !ok {
+		return
+	}
+	switch cmd {
+	case "0", "2":
+		p.t.title = text
+	}
+}
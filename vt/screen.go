@@ -0,0 +1,442 @@
+package vt
+
+type charset uint8
+
+const (
+	charsetASCII charset = iota
+	charsetDECGraphics
+)
+
+type cursor struct {
+	row, col int
+	style    Style
+
+	// Set after writing to the last column with autowrap enabled; the next
+	// printable character wraps to the next line first.
+	pendingWrap bool
+
+	charsets [2]charset // G0, G1
+	gl       int        // charset invoked by SI (0) / SO (1)
+	origin   bool
+}
+
+type screen struct {
+	lines  [][]Cell
+	cursor cursor
+	saved  cursor // DECSC
+}
+
+func blankCell(st Style) Cell {
+	// Erased cells keep the background only (xterm's BCE).
+	return Cell{Rune: ' ', Width: 1, Style: Style{Bg: st.Bg}}
+}
+
+func blankLine(cols int, st Style) []Cell {
+	l := make([]Cell, cols)
+	for i := range l {
+		l[i] = blankCell(st)
+	}
+	return l
+}
+
+func newScreen(rows, cols int) *screen {
+	s := &screen{lines: make([][]Cell, rows)}
+	for i := range s.lines {
+		s.lines[i] = blankLine(cols, Style{})
+	}
+	return s
+}
+
+// reset puts the terminal into its power-on state. The scrollback buffer is
+// kept.
+func (t *Terminal) reset(rows, cols int) {
+	t.rows, t.cols = rows, cols
+	t.main = newScreen(rows, cols)
+	t.alt = newScreen(rows, cols)
+	t.cur = t.main
+	t.top, t.bottom = 0, rows-1
+	t.autowrap = true
+	t.insert = false
+	t.cursorVisible = true
+	t.title = ""
+	t.resetTabs()
+}
+
+func (t *Terminal) resetTabs() {
+	t.tabs = make([]bool, t.cols)
+	for i := 8; i < t.cols; i += 8 {
+		t.tabs[i] = true
+	}
+}
+
+func (t *Terminal) resize(rows, cols int) {
+	if rows == t.rows && cols == t.cols {
+		return
+	}
+
+	for _, s := range []*screen{t.main, t.alt} {
+		// Keep the cursor on screen: move lines above it out of the
+		// screen (into the scrollback buffer for the main screen) first,
+		// then drop lines below the cursor.
+		if over := s.cursor.row + 1 - rows; over > 0 {
+			if s == t.main {
+				for _, l := range s.lines[:over] {
+					t.pushScrollback(l)
+				}
+			}
+			s.lines = s.lines[over:]
+			s.cursor.row -= over
+			s.saved.row = max(s.saved.row-over, 0)
+		}
+		if len(s.lines) > rows {
+			s.lines = s.lines[:rows]
+		}
+		for len(s.lines) < rows {
+			s.lines = append(s.lines, blankLine(cols, Style{}))
+		}
+
+		for i, l := range s.lines {
+			s.lines[i] = resizeLine(l, cols)
+		}
+
+		s.cursor.row = min(s.cursor.row, rows-1)
+		s.cursor.col = min(s.cursor.col, cols-1)
+		s.cursor.pendingWrap = false
+		s.saved.row = min(s.saved.row, rows-1)
+		s.saved.col = min(s.saved.col, cols-1)
+	}
+
+	t.rows, t.cols = rows, cols
+	t.top, t.bottom = 0, rows-1
+
+	oldTabs := t.tabs
+	t.resetTabs()
+	copy(t.tabs, oldTabs)
+}
+
+func resizeLine(l []Cell, cols int) []Cell {
+	if len(l) > cols {
+		l = l[:cols]
+		// Do not leave half of a wide character behind.
+		if last := &l[cols-1]; last.Width == 2 {
+			*last = blankCell(last.Style)
+		}
+		return l
+	}
+	for len(l) < cols {
+		l = append(l, blankCell(Style{}))
+	}
+	return l
+}
+
+func (t *Terminal) pushScrollback(l []Cell) {
+	if t.maxScrollback < 0 {
+		return
+	}
+	t.scrollback = append(t.scrollback, append([]Cell(nil), l...))
+	if over := len(t.scrollback) - t.maxScrollback; over > 0 {
+		t.scrollback = append(t.scrollback[:0], t.scrollback[over:]...)
+	}
+}
+
+func (t *Terminal) print(r rune) {
+	c := &t.cur.cursor
+
+	if c.charsets[c.gl] == charsetDECGraphics {
+		r = decGraphics(r)
+	}
+
+	w := runeWidth(r)
+	if w == 0 {
+		return
+	}
+	if w > t.cols {
+		// A wide character can not fit on a single column screen.
+		r, w = ' ', 1
+	}
+
+	if c.pendingWrap || (w == 2 && c.col == t.cols-1) {
+		if t.autowrap {
+			if !c.pendingWrap {
+				t.setCell(c.row, c.col, blankCell(c.style))
+			}
+			c.col = 0
+			t.index()
+		} else if w == 2 {
+			c.col = t.cols - 2
+		}
+		c.pendingWrap = false
+	}
+
+	if t.insert {
+		t.insertChars(w)
+	}
+
+	t.setCell(c.row, c.col, Cell{Rune: r, Width: uint8(w), Style: c.style})
+	if w == 2 {
+		t.setCell(c.row, c.col+1, Cell{Rune: 0, Width: 0, Style: c.style})
+	}
+
+	if c.col+w >= t.cols {
+		c.col = t.cols - 1
+		c.pendingWrap = t.autowrap
+	} else {
+		c.col += w
+	}
+}
+
+// setCell writes a cell, fixing up any wide character it overlaps.
+func (t *Terminal) setCell(row, col int, cell Cell) {
+	l := t.cur.lines[row]
+	if l[col].Width == 0 && col > 0 {
+		// Overwriting the right half of a wide character.
+		l[col-1] = blankCell(l[col-1].Style)
+	}
+	if l[col].Width == 2 && col+1 < len(l) && cell.Width != 2 {
+		// Overwriting the left half of a wide character.
+		l[col+1] = blankCell(l[col+1].Style)
+	}
+	l[col] = cell
+}
+
+// index moves the cursor down, scrolling the region at its bottom margin.
+func (t *Terminal) index() {
+	c := &t.cur.cursor
+	if c.row == t.bottom {
+		t.scrollUp(1)
+	} else if c.row < t.rows-1 {
+		c.row++
+	}
+}
+
+// reverseIndex moves the cursor up, scrolling the region at its top margin.
+func (t *Terminal) reverseIndex() {
+	c := &t.cur.cursor
+	c.pendingWrap = false
+	if c.row == t.top {
+		t.scrollDown(1)
+	} else if c.row > 0 {
+		c.row--
+	}
+}
+
+func (t *Terminal) scrollUp(n int) {
+	t.scrollRegionUp(n, t.top == 0 && t.cur == t.main)
+}
+
+func (t *Terminal) scrollRegionUp(n int, saveToScrollback bool) {
+	n = min(n, t.bottom-t.top+1)
+	lines := t.cur.lines
+	for i := 0; i < n; i++ {
+		if saveToScrollback {
+			t.pushScrollback(lines[t.top])
+		}
+		copy(lines[t.top:t.bottom], lines[t.top+1:t.bottom+1])
+		lines[t.bottom] = blankLine(t.cols, t.cur.cursor.style)
+	}
+}
+
+func (t *Terminal) scrollDown(n int) {
+	n = min(n, t.bottom-t.top+1)
+	lines := t.cur.lines
+	for i := 0; i < n; i++ {
+		copy(lines[t.top+1:t.bottom+1], lines[t.top:t.bottom])
+		lines[t.top] = blankLine(t.cols, t.cur.cursor.style)
+	}
+}
+
+// setCursor moves the cursor to a 0-based position. With origin mode, row
+// is relative to the scroll region and the cursor stays inside it.
+func (t *Terminal) setCursor(row, col int) {
+	c := &t.cur.cursor
+	minRow, maxRow := 0, t.rows-1
+	if c.origin {
+		row += t.top
+		minRow, maxRow = t.top, t.bottom
+	}
+	c.row = min(max(row, minRow), maxRow)
+	c.col = min(max(col, 0), t.cols-1)
+	c.pendingWrap = false
+}
+
+// moveCursor moves the cursor relatively, stopping at the scroll region
+// margins if the cursor starts inside the region.
+func (t *Terminal) moveCursor(drow, dcol int) {
+	c := &t.cur.cursor
+	minRow, maxRow := 0, t.rows-1
+	if c.row >= t.top && c.row <= t.bottom {
+		minRow, maxRow = t.top, t.bottom
+	}
+	c.row = min(max(c.row+drow, minRow), maxRow)
+	c.col = min(max(c.col+dcol, 0), t.cols-1)
+	c.pendingWrap = false
+}
+
+func (t *Terminal) tab(n int) {
+	c := &t.cur.cursor
+	for ; n > 0 && c.col < t.cols-1; n-- {
+		c.col++
+		for c.col < t.cols-1 && !t.tabs[c.col] {
+			c.col++
+		}
+	}
+	c.pendingWrap = false
+}
+
+func (t *Terminal) backTab(n int) {
+	c := &t.cur.cursor
+	for ; n > 0 && c.col > 0; n-- {
+		c.col--
+		for c.col > 0 && !t.tabs[c.col] {
+			c.col--
+		}
+	}
+	c.pendingWrap = false
+}
+
+func (t *Terminal) eraseCells(row, from, to int) {
+	l := t.cur.lines[row]
+	from, to = max(from, 0), min(to, t.cols)
+	if from >= to {
+		return
+	}
+	// Erasing half of a wide character erases all of it.
+	if l[from].Width == 0 && from > 0 {
+		l[from-1] = blankCell(l[from-1].Style)
+	}
+	if to < t.cols && l[to].Width == 0 {
+		l[to] = blankCell(l[to].Style)
+	}
+	for i := from; i < to; i++ {
+		l[i] = blankCell(t.cur.cursor.style)
+	}
+}
+
+func (t *Terminal) eraseInLine(mode int) {
+	c := &t.cur.cursor
+	switch mode {
+	case 0:
+		t.eraseCells(c.row, c.col, t.cols)
+	case 1:
+		t.eraseCells(c.row, 0, c.col+1)
+	case 2:
+		t.eraseCells(c.row, 0, t.cols)
+	}
+}
+
+func (t *Terminal) eraseInDisplay(mode int) {
+	c := &t.cur.cursor
+	switch mode {
+	case 0:
+		t.eraseCells(c.row, c.col, t.cols)
+		for r := c.row + 1; r < t.rows; r++ {
+			t.eraseCells(r, 0, t.cols)
+		}
+	case 1:
+		for r := 0; r < c.row; r++ {
+			t.eraseCells(r, 0, t.cols)
+		}
+		t.eraseCells(c.row, 0, c.col+1)
+	case 2:
+		for r := 0; r < t.rows; r++ {
+			t.eraseCells(r, 0, t.cols)
+		}
+	case 3:
+		t.scrollback = nil
+	}
+}
+
+func (t *Terminal) insertChars(n int) {
+	c := &t.cur.cursor
+	l := t.cur.lines[c.row]
+	n = min(n, t.cols-c.col)
+	copy(l[c.col+n:], l[c.col:t.cols-n])
+	for i := c.col; i < c.col+n; i++ {
+		l[i] = blankCell(c.style)
+	}
+	// A wide character pushed past the right margin is cut in half.
+	if l[t.cols-1].Width == 2 {
+		l[t.cols-1] = blankCell(l[t.cols-1].Style)
+	}
+	c.pendingWrap = false
+}
+
+func (t *Terminal) deleteChars(n int) {
+	c := &t.cur.cursor
+	l := t.cur.lines[c.row]
+	n = min(n, t.cols-c.col)
+	if l[c.col].Width == 0 && c.col > 0 {
+		l[c.col-1] = blankCell(l[c.col-1].Style)
+	}
+	copy(l[c.col:], l[c.col+n:])
+	for i := t.cols - n; i < t.cols; i++ {
+		l[i] = blankCell(c.style)
+	}
+	if l[c.col].Width == 0 {
+		l[c.col] = blankCell(l[c.col].Style)
+	}
+	c.pendingWrap = false
+}
+
+func (t *Terminal) insertLines(n int) {
+	c := &t.cur.cursor
+	if c.row < t.top || c.row > t.bottom {
+		return
+	}
+	top := t.top
+	t.top = c.row
+	t.scrollDown(n)
+	t.top = top
+	c.col = 0
+	c.pendingWrap = false
+}
+
+func (t *Terminal) deleteLines(n int) {
+	c := &t.cur.cursor
+	if c.row < t.top || c.row > t.bottom {
+		return
+	}
+	top := t.top
+	t.top = c.row
+	t.scrollRegionUp(n, false)
+	t.top = top
+	c.col = 0
+	c.pendingWrap = false
+}
+
+func (t *Terminal) setScrollRegion(top, bottom int) {
+	top = max(top, 0)
+	bottom = min(bottom, t.rows-1)
+	if top >= bottom {
+		return
+	}
+	t.top, t.bottom = top, bottom
+	t.setCursor(0, 0)
+}
+
+func (t *Terminal) saveCursor() {
+	t.cur.saved = t.cur.cursor
+}
+
+func (t *Terminal) restoreCursor() {
+	t.cur.cursor = t.cur.saved
+	t.cur.cursor.row = min(t.cur.cursor.row, t.rows-1)
+	t.cur.cursor.col = min(t.cur.cursor.col, t.cols-1)
+}
+
+// switchScreen switches between the main and the alternate screen. The
+// cursor moves along, and the alternate screen is cleared when entered.
+func (t *Terminal) switchScreen(alt bool) {
+	if alt == (t.cur == t.alt) {
+		return
+	}
+	cur := t.cur.cursor
+	if alt {
+		t.cur = t.alt
+		t.cur.cursor = cur
+		t.eraseInDisplay(2)
+	} else {
+		t.cur = t.main
+		t.cur.cursor = cur
+	}
+}
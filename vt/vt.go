@@ -0,0 +1,263 @@
+// Package vt provides a headless VT terminal emulator.
+//
+// A Terminal consumes PTY output (usually everything read from a
+// crosspty.Pty) and maintains a virtual screen: a cell grid, the cursor, SGR
+// attributes, scroll regions, the alternate screen, line wrapping and a
+// scrollback buffer. It answers "what is on screen right now", either as text
+// or as styled cells, which is handy for TUI automation and test snapshots.
+//
+// The emulation targets the common subset of xterm used by real-world
+// programs. It does not reflow lines on resize, and zero-width characters
+// (combining marks, ZWJ, ...) are dropped.
+//
+//	p, _ := crosspty.Start(cc)
+//	term := vt.New(vt.Config{Size: cc.Size, Reply: p})
+//	go io.Copy(term, p)
+//	// later
+//	fmt.Println(term.String())
+package vt
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/Kodecable/crosspty"
+)
+
+type ColorMode uint8
+
+const (
+	ColorDefault ColorMode = iota // Terminal default color.
+	ColorIndexed                  // One of the 256 palette colors, see Color.Index.
+	ColorRGB                      // 24-bit color, see Color.R, Color.G and Color.B.
+)
+
+type Color struct {
+	Mode    ColorMode
+	Index   uint8
+	R, G, B uint8
+}
+
+type Attr uint16
+
+const (
+	AttrBold Attr = 1 << iota
+	AttrFaint
+	AttrItalic
+	AttrUnderline
+	AttrBlink
+	AttrReverse
+	AttrHidden
+	AttrStrike
+)
+
+type Style struct {
+	Fg    Color
+	Bg    Color
+	Attrs Attr
+}
+
+// Cell is a single character cell of the screen.
+type Cell struct {
+	// ' ' for blank cells. 0 for the right half of a wide character.
+	Rune rune
+	// Number of columns occupied by Rune: 1, 2 for wide characters, or 0 for
+	// the right half of a wide character.
+	Width uint8
+	Style Style
+}
+
+type Config struct {
+	// default: 24x80
+	Size crosspty.TermSize
+
+	// Maximum number of lines kept in the scrollback buffer.
+	// Negative disables the scrollback buffer.
+	// default: 1000
+	MaxScrollback int
+
+	// If not nil, answers to terminal queries (device status report, device
+	// attributes) are written to it. Usually this is the Pty.
+	Reply io.Writer
+}
+
+// Terminal is a virtual terminal screen.
+// Thread-safe.
+type Terminal struct {
+	mu sync.Mutex
+
+	reply io.Writer
+
+	rows, cols int
+
+	main, alt *screen
+	cur       *screen // main or alt
+
+	scrollback    [][]Cell
+	maxScrollback int
+
+	top, bottom int // scroll region, inclusive
+
+	autowrap      bool
+	insert        bool
+	cursorVisible bool
+
+	tabs []bool
+
+	title string
+
+	parser parser
+}
+
+func New(cfg Config) *Terminal {
+	if cfg.Size.Rows == 0 || cfg.Size.Cols == 0 {
+		cfg.Size = crosspty.TermSize{Rows: 24, Cols: 80}
+	}
+	if cfg.MaxScrollback == 0 {
+		cfg.MaxScrollback = 1000
+	}
+
+	t := &Terminal{
+		reply:         cfg.Reply,
+		maxScrollback: cfg.MaxScrollback,
+	}
+	t.parser.t = t
+	t.reset(int(cfg.Size.Rows), int(cfg.Size.Cols))
+	return t
+}
+
+// Write feeds PTY output into the terminal. It never fails, partial UTF-8
+// sequences and escape sequences are kept until the next Write.
+func (t *Terminal) Write(d []byte) (int, error) {
+	t.mu.Lock()
+	t.parser.feed(d)
+	replies := t.parser.takeReplies()
+	t.mu.Unlock()
+
+	if t.reply != nil && len(replies) != 0 {
+		t.reply.Write(replies)
+	}
+	return len(d), nil
+}
+
+// Resize the screen. Call it together with Pty.Resize.
+// Lines are not reflowed. When the screen shrinks, lines above the cursor
+// are moved into the scrollback buffer to keep the cursor on screen.
+func (t *Terminal) Resize(sz crosspty.TermSize) {
+	if sz.Rows == 0 || sz.Cols == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.resize(int(sz.Rows), int(sz.Cols))
+}
+
+func (t *Terminal) Size() crosspty.TermSize {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return crosspty.TermSize{Rows: uint16(t.rows), Cols: uint16(t.cols)}
+}
+
+// Cursor returns the 0-based cursor position.
+func (t *Terminal) Cursor() (row, col int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cur.cursor.row, t.cur.cursor.col
+}
+
+func (t *Terminal) CursorVisible() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cursorVisible
+}
+
+// AltScreen reports whether the alternate screen is active.
+func (t *Terminal) AltScreen() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cur == t.alt
+}
+
+// Title returns the window title set by OSC 0 or OSC 2.
+func (t *Terminal) Title() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.title
+}
+
+// Cell returns the cell at the 0-based position. Out of range positions
+// return a blank cell.
+func (t *Terminal) Cell(row, col int) Cell {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if row < 0 || row >= t.rows || col < 0 || col >= t.cols {
+		return blankCell(Style{})
+	}
+	return t.cur.lines[row][col]
+}
+
+// Cells returns a copy of the visible screen.
+func (t *Terminal) Cells() [][]Cell {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return copyLines(t.cur.lines)
+}
+
+// Scrollback returns a copy of the scrollback buffer, oldest line first.
+// Lines may be shorter or longer than the current width.
+func (t *Terminal) Scrollback() [][]Cell {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return copyLines(t.scrollback)
+}
+
+// Lines returns the text of each visible row, with trailing blanks removed.
+func (t *Terminal) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return linesText(t.cur.lines)
+}
+
+// ScrollbackLines returns the text of the scrollback buffer, oldest line
+// first, with trailing blanks removed.
+func (t *Terminal) ScrollbackLines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return linesText(t.scrollback)
+}
+
+// String returns the text on screen. Trailing blanks of each line and
+// trailing empty lines are removed.
+func (t *Terminal) String() string {
+	lines := t.Lines()
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func copyLines(lines [][]Cell) [][]Cell {
+	out := make([][]Cell, len(lines))
+	for i, l := range lines {
+		out[i] = append([]Cell(nil), l...)
+	}
+	return out
+}
+
+func linesText(lines [][]Cell) []string {
+	out := make([]string, len(lines))
+	var sb strings.Builder
+	for i, l := range lines {
+		sb.Reset()
+		for _, c := range l {
+			if c.Width == 0 {
+				continue
+			}
+			sb.WriteRune(c.Rune)
+		}
+		out[i] = strings.TrimRight(sb.String(), " ")
+	}
+	return out
+}
@@ -0,0 +1,246 @@
+package vt_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/vt"
+)
+
+func newTerm(rows, cols int) *vt.Terminal {
+	return vt.New(vt.Config{Size: crosspty.TermSize{Rows: uint16(rows), Cols: uint16(cols)}})
+}
+
+func assertScreen(t *testing.T, term *vt.Terminal, want string) {
+	t.Helper()
+	if got := term.String(); got != want {
+		t.Fatalf("screen mismatch:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func assertCursor(t *testing.T, term *vt.Terminal, row, col int) {
+	t.Helper()
+	if r, c := term.Cursor(); r != row || c != col {
+		t.Fatalf("cursor mismatch: got (%d, %d), want (%d, %d)", r, c, row, col)
+	}
+}
+
+func TestText(t *testing.T) {
+	term := newTerm(24, 80)
+	term.Write([]byte("hello\r\nworld"))
+
+	assertScreen(t, term, "hello\nworld")
+	assertCursor(t, term, 1, 5)
+}
+
+func TestSplitWrites(t *testing.T) {
+	term := newTerm(24, 80)
+	for _, b := range []byte("\x1b[31mé\x1b]0;title\x07\x1b[2;3Hx") {
+		term.Write([]byte{b})
+	}
+
+	assertScreen(t, term, "é\n  x")
+	if c := term.Cell(0, 0); c.Style.Fg != (vt.Color{Mode: vt.ColorIndexed, Index: 1}) {
+		t.Fatalf("expected red foreground, got %+v", c.Style)
+	}
+	if term.Title() != "title" {
+		t.Fatalf("expected title to be set, got %q", term.Title())
+	}
+}
+
+func TestAutowrap(t *testing.T) {
+	term := newTerm(3, 5)
+	term.Write([]byte("abcde"))
+	assertCursor(t, term, 0, 4)
+
+	term.Write([]byte("fg"))
+	assertScreen(t, term, "abcde\nfg")
+	assertCursor(t, term, 1, 2)
+
+	term.Write([]byte("\x1b[?7l\x1b[3;1H12345678"))
+	assertScreen(t, term, "abcde\nfg\n12348")
+}
+
+func TestScrollback(t *testing.T) {
+	term := vt.New(vt.Config{
+		Size:          crosspty.TermSize{Rows: 3, Cols: 10},
+		MaxScrollback: 2,
+	})
+	term.Write([]byte("1\r\n2\r\n3\r\n4\r\n5"))
+
+	assertScreen(t, term, "3\n4\n5")
+	if got := term.ScrollbackLines(); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Fatalf("unexpected scrollback: %q", got)
+	}
+
+	term.Write([]byte("\x1b[3J"))
+	if got := term.ScrollbackLines(); len(got) != 0 {
+		t.Fatalf("expected scrollback to be cleared, got %q", got)
+	}
+}
+
+func TestScrollRegion(t *testing.T) {
+	term := newTerm(5, 10)
+	term.Write([]byte("a\r\nb\r\nc\r\nd\r\ne"))
+	term.Write([]byte("\x1b[2;4r\x1b[4;1H\nX"))
+
+	assertScreen(t, term, "a\nc\nd\nX\ne")
+	if got := term.ScrollbackLines(); len(got) != 0 {
+		t.Fatalf("expected no scrollback from a scroll region, got %q", got)
+	}
+
+	// Reverse index at the top margin scrolls the region down.
+	term.Write([]byte("\x1b[2;1H\x1bMY"))
+	assertScreen(t, term, "a\nY\nc\nd\ne")
+}
+
+func TestSGR(t *testing.T) {
+	term := newTerm(2, 10)
+	term.Write([]byte("\x1b[1;31mA\x1b[0mB\x1b[38;5;200;4mC\x1b[48;2;1;2;3mD\x1b[38:2::4:5:6mE\x1b[22;24;39;49mF"))
+
+	cells := term.Cells()[0]
+	want := []vt.Style{
+		{Fg: vt.Color{Mode: vt.ColorIndexed, Index: 1}, Attrs: vt.AttrBold},
+		{},
+		{Fg: vt.Color{Mode: vt.ColorIndexed, Index: 200}, Attrs: vt.AttrUnderline},
+		{Fg: vt.Color{Mode: vt.ColorIndexed, Index: 200}, Bg: vt.Color{Mode: vt.ColorRGB, R: 1, G: 2, B: 3}, Attrs: vt.AttrUnderline},
+		{Fg: vt.Color{Mode: vt.ColorRGB, R: 4, G: 5, B: 6}, Bg: vt.Color{Mode: vt.ColorRGB, R: 1, G: 2, B: 3}, Attrs: vt.AttrUnderline},
+		{},
+	}
+	for i, st := range want {
+		if cells[i].Style != st {
+			t.Errorf("cell %d (%q): got style %+v, want %+v", i, cells[i].Rune, cells[i].Style, st)
+		}
+	}
+}
+
+func TestCursorAndErase(t *testing.T) {
+	term := newTerm(3, 10)
+	term.Write([]byte("0123456789\r\nabcdefghij\r\nABCDEFGHIJ"))
+
+	term.Write([]byte("\x1b[1;5H\x1b[K"))
+	term.Write([]byte("\x1b[2;5H\x1b[1K"))
+	term.Write([]byte("\x1b[3;3H\x1b[2P\x1b[1@"))
+	assertScreen(t, term, "0123\n     fghij\nAB EFGHIJ")
+
+	term.Write([]byte("\x1b[2;1H\x1b[J"))
+	assertScreen(t, term, "0123")
+	assertCursor(t, term, 1, 0)
+}
+
+func TestInsertDeleteLines(t *testing.T) {
+	term := newTerm(4, 10)
+	term.Write([]byte("a\r\nb\r\nc\r\nd"))
+
+	term.Write([]byte("\x1b[2;1H\x1b[L"))
+	assertScreen(t, term, "a\n\nb\nc")
+
+	term.Write([]byte("\x1b[M\x1b[M"))
+	assertScreen(t, term, "a\nc")
+}
+
+func TestAltScreen(t *testing.T) {
+	term := newTerm(3, 10)
+	term.Write([]byte("main\r\nscreen"))
+
+	term.Write([]byte("\x1b[?1049h"))
+	if !term.AltScreen() {
+		t.Fatal("expected alternate screen to be active")
+	}
+	term.Write([]byte("\x1b[Halt"))
+	assertScreen(t, term, "alt")
+
+	term.Write([]byte("\x1b[?1049l"))
+	if term.AltScreen() {
+		t.Fatal("expected main screen to be active")
+	}
+	assertScreen(t, term, "main\nscreen")
+	assertCursor(t, term, 1, 6)
+}
+
+func TestWideChars(t *testing.T) {
+	term := newTerm(2, 5)
+	term.Write([]byte("a你好"))
+
+	assertScreen(t, term, "a你好")
+	if c := term.Cell(0, 1); c.Rune != '你' || c.Width != 2 {
+		t.Fatalf("unexpected wide cell: %+v", c)
+	}
+	if c := term.Cell(0, 2); c.Width != 0 {
+		t.Fatalf("expected continuation cell, got %+v", c)
+	}
+
+	// Not enough room on the line: the wide character wraps.
+	term.Write([]byte("\x1b[H1234世"))
+	assertScreen(t, term, "1234\n世")
+
+	// Overwriting half of a wide character erases the other half.
+	term.Write([]byte("\x1b[2;2Hx"))
+	assertScreen(t, term, "1234\n x")
+}
+
+func TestResize(t *testing.T) {
+	term := newTerm(4, 10)
+	term.Write([]byte("1\r\n2\r\n3\r\n4"))
+
+	term.Resize(crosspty.TermSize{Rows: 2, Cols: 5})
+	assertScreen(t, term, "3\n4")
+	assertCursor(t, term, 1, 1)
+	if got := term.ScrollbackLines(); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Fatalf("unexpected scrollback: %q", got)
+	}
+	if sz := term.Size(); sz.Rows != 2 || sz.Cols != 5 {
+		t.Fatalf("unexpected size: %+v", sz)
+	}
+
+	term.Resize(crosspty.TermSize{Rows: 3, Cols: 8})
+	term.Write([]byte("\r\n12345678"))
+	assertScreen(t, term, "3\n4\n12345678")
+}
+
+func TestReply(t *testing.T) {
+	var reply bytes.Buffer
+	term := vt.New(vt.Config{Reply: &reply})
+	term.Write([]byte("\x1b[3;7H\x1b[6n\x1b[5n\x1b[c"))
+
+	if got, want := reply.String(), "\x1b[3;7R\x1b[0n\x1b[?1;2c"; got != want {
+		t.Fatalf("unexpected reply: %q, want %q", got, want)
+	}
+}
+
+func TestDECGraphics(t *testing.T) {
+	term := newTerm(2, 10)
+	term.Write([]byte("\x1b(0lqk\x1b(Bx"))
+	assertScreen(t, term, "┌─┐x")
+}
+
+func TestLongIntermediatesIgnored(t *testing.T) {
+	term := newTerm(2, 10)
+	long := strings.Repeat("(", 1<<16)
+	term.Write([]byte("\x1b" + long + "0q\x1b[" + long + "mx"))
+	assertScreen(t, term, "qx")
+}
+
+func TestTabsAndBackspace(t *testing.T) {
+	term := newTerm(2, 20)
+	term.Write([]byte("a\tb\bc\x1b[3gd\te"))
+	if got := term.Lines()[0]; got != "a       cd         e" {
+		t.Fatalf("unexpected line: %q", got)
+	}
+}
+
+func TestHiddenCursorAndReset(t *testing.T) {
+	term := newTerm(2, 10)
+	term.Write([]byte("\x1b[?25lhello"))
+	if term.CursorVisible() {
+		t.Fatal("expected cursor to be hidden")
+	}
+
+	term.Write([]byte("\x1bc"))
+	if !term.CursorVisible() || strings.TrimSpace(term.String()) != "" {
+		t.Fatalf("expected reset terminal, got %q", term.String())
+	}
+}
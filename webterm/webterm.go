@@ -0,0 +1,254 @@
+// Package webterm exposes processes to browsers (e.g. xterm.js) over
+// WebSocket.
+//
+// Each WebSocket connection starts its own process. The protocol is:
+//
+//   - Server to client: binary frames carry PTY output. When the process
+//     exits, a text frame {"type":"exit","code":0,"signal":""} is sent and
+//     the connection is closed; signal is the name of the terminating
+//     signal, e.g. "KILL". If the process cannot be started,
+//     {"type":"error","message":"..."} is sent instead.
+//   - Client to server: binary frames carry input. Text frames carry JSON
+//     control messages:
+//     {"type":"resize","cols":80,"rows":24},
+//     {"type":"signal","signal":"INT"} and
+//     {"type":"close"}, which runs the Close() sequence of the Pty.
+//
+// The initial size can be passed in the query string, e.g.
+// ws://host/term?cols=80&rows=24. The process is closed when the client
+// disconnects.
+//
+// A minimal xterm.js client:
+//
+//	const ws = new WebSocket(url + "?cols=" + term.cols + "&rows=" + term.rows);
+//	ws.binaryType = "arraybuffer";
+//	ws.onmessage = (e) => typeof e.data === "string"
+//	    ? console.log(JSON.parse(e.data))
+//	    : term.write(new Uint8Array(e.data));
+//	term.onData((d) => ws.send(new TextEncoder().encode(d)));
+//	term.onResize(({ cols, rows }) =>
+//	    ws.send(JSON.stringify({ type: "resize", cols, rows })));
+package webterm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/internal/procsignal"
+
+	"golang.org/x/net/websocket"
+)
+
+type Config struct {
+	// The process to start for each connection. The size from the query
+	// string, if any, overrides Command.Size.
+	Command crosspty.CommandConfig
+
+	// If not nil, called for each connection instead of using Command, e.g.
+	// to pick a command per user. Returning an error rejects the connection
+	// after the WebSocket handshake, with an "error" message.
+	CommandFunc func(r *http.Request) (crosspty.CommandConfig, error)
+
+	// Reports whether a connection from the Origin of r is allowed. Without
+	// this check any web page could run commands through the browser of a
+	// user, so think twice before allowing everything.
+	// default: requests without Origin (non-browser clients) and requests
+	// whose Origin host equals the Host header
+	CheckOrigin func(r *http.Request) bool
+
+	// Maximum size of a message from the client.
+	// default: 1 MiB
+	MaxMessageSize int
+}
+
+type controlMessage struct {
+	Type string `json:"type"`
+
+	// resize
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+
+	// signal
+	Signal string `json:"signal,omitempty"`
+}
+
+type exitMessage struct {
+	Type   string `json:"type"`
+	Code   int    `json:"code"`
+	Signal string `json:"signal"`
+}
+
+type errorMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// message is a received or sent WebSocket message.
+type message struct {
+	binary bool
+	data   []byte
+}
+
+var codec = websocket.Codec{
+	Marshal: func(v any) ([]byte, byte, error) {
+		m := v.(message)
+		if m.binary {
+			return m.data, websocket.BinaryFrame, nil
+		}
+		return m.data, websocket.TextFrame, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v any) error {
+		*v.(*message) = message{binary: payloadType == websocket.BinaryFrame, data: data}
+		return nil
+	},
+}
+
+type handler struct {
+	cfg Config
+	ws  websocket.Server
+}
+
+// NewHandler returns an http.Handler that serves WebSocket connections.
+func NewHandler(cfg Config) http.Handler {
+	if cfg.CheckOrigin == nil {
+		cfg.CheckOrigin = sameOrigin
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = 1 << 20
+	}
+
+	h := &handler{cfg: cfg}
+	h.ws = websocket.Server{
+		Handshake: h.handshake,
+		Handler:   h.serve,
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.cfg.CheckOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	h.ws.ServeHTTP(w, r)
+}
+
+// handshake accepts any Origin, CheckOrigin already ran.
+func (h *handler) handshake(cfg *websocket.Config, r *http.Request) error {
+	return nil
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func (h *handler) serve(ws *websocket.Conn) {
+	defer ws.Close()
+	ws.MaxPayloadBytes = h.cfg.MaxMessageSize
+	r := ws.Request()
+
+	cc := h.cfg.Command
+	if h.cfg.CommandFunc != nil {
+		var err error
+		if cc, err = h.cfg.CommandFunc(r); err != nil {
+			sendJSON(ws, errorMessage{Type: "error", Message: err.Error()})
+			return
+		}
+	}
+	if sz, ok := sizeFromQuery(r.URL.Query()); ok {
+		cc.Size = sz
+	}
+
+	p, err := crosspty.Start(cc)
+	if err != nil {
+		sendJSON(ws, errorMessage{Type: "error", Message: err.Error()})
+		return
+	}
+	defer p.Close()
+
+	go h.output(ws, p)
+
+	for {
+		var m message
+		if err := codec.Receive(ws, &m); err != nil {
+			if errors.Is(err, websocket.ErrFrameTooLarge) {
+				continue
+			}
+			return
+		}
+
+		if m.binary {
+			if _, err := p.Write(m.data); err != nil {
+				return
+			}
+			continue
+		}
+
+		var ctl controlMessage
+		if err := json.Unmarshal(m.data, &ctl); err != nil {
+			continue
+		}
+		switch ctl.Type {
+		case "resize":
+			if ctl.Rows != 0 && ctl.Cols != 0 {
+				p.Resize(crosspty.TermSize{Rows: ctl.Rows, Cols: ctl.Cols})
+			}
+		case "signal":
+			procsignal.Send(p, ctl.Signal)
+		case "close":
+			// output() reports the exit and closes the connection.
+			go p.Close()
+		}
+	}
+}
+
+// output copies PTY output to ws until EOF, then reports the exit status and
+// closes ws.
+func (h *handler) output(ws *websocket.Conn, p crosspty.Pty) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := p.Read(buf)
+		if n > 0 {
+			if codec.Send(ws, message{binary: true, data: buf[:n]}) != nil {
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	st := p.WaitStatus()
+	msg := exitMessage{Type: "exit", Code: st.Code}
+	if st.Signal != 0 {
+		msg.Signal = procsignal.Name(st.Signal)
+	}
+	sendJSON(ws, msg)
+	ws.Close()
+}
+
+func sendJSON(ws *websocket.Conn, v any) error {
+	d, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return codec.Send(ws, message{data: d})
+}
+
+func sizeFromQuery(q url.Values) (crosspty.TermSize, bool) {
+	cols, err1 := strconv.ParseUint(q.Get("cols"), 10, 16)
+	rows, err2 := strconv.ParseUint(q.Get("rows"), 10, 16)
+	if err1 != nil || err2 != nil || cols == 0 || rows == 0 {
+		return crosspty.TermSize{}, false
+	}
+	return crosspty.TermSize{Rows: uint16(rows), Cols: uint16(cols)}, true
+}
@@ -0,0 +1,219 @@
+package webterm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kodecable/crosspty"
+	"github.com/Kodecable/crosspty/webterm"
+
+	"golang.org/x/net/websocket"
+)
+
+func skipWindows(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+}
+
+func dial(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/" + query
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	ws.SetDeadline(time.Now().Add(10 * time.Second))
+	return ws
+}
+
+type frame struct {
+	binary bool
+	data   []byte
+}
+
+// frames receives frames and tells binary and text frames apart.
+var frames = websocket.Codec{
+	Unmarshal: func(data []byte, payloadType byte, v any) error {
+		*v.(*frame) = frame{binary: payloadType == websocket.BinaryFrame, data: data}
+		return nil
+	},
+}
+
+// readUntilExit collects output until the exit message.
+func readUntilExit(t *testing.T, ws *websocket.Conn) (output string, exit map[string]any) {
+	t.Helper()
+	var out strings.Builder
+	for {
+		var f frame
+		if err := frames.Receive(ws, &f); err != nil {
+			t.Fatalf("receive: %v (output %q)", err, out.String())
+		}
+		if !f.binary {
+			if err := json.Unmarshal(f.data, &exit); err != nil {
+				t.Fatalf("bad control message %q: %v", f.data, err)
+			}
+			return out.String(), exit
+		}
+		out.Write(f.data)
+	}
+}
+
+func TestWebtermSession(t *testing.T) {
+	skipWindows(t)
+	srv := httptest.NewServer(webterm.NewHandler(webterm.Config{
+		Command: crosspty.CommandConfig{
+			Argv: []string{"sh", "-c", "stty size; read line; stty size; echo got $line; exit 3"},
+		},
+	}))
+	defer srv.Close()
+
+	ws := dial(t, srv, "?cols=100&rows=30")
+	defer ws.Close()
+
+	var first strings.Builder
+	for !strings.Contains(first.String(), "30 100") {
+		var frame []byte
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			t.Fatalf("receive: %v (output %q)", err, first.String())
+		}
+		first.Write(frame)
+	}
+
+	websocket.Message.Send(ws, `{"type":"resize","cols":120,"rows":40}`)
+	websocket.Message.Send(ws, []byte("hello\r"))
+
+	out, exit := readUntilExit(t, ws)
+	if !strings.Contains(out, "40 120") || !strings.Contains(out, "got hello") {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	if exit["type"] != "exit" || exit["code"] != 3.0 {
+		t.Fatalf("unexpected exit message: %v", exit)
+	}
+}
+
+func TestWebtermSignal(t *testing.T) {
+	skipWindows(t)
+	srv := httptest.NewServer(webterm.NewHandler(webterm.Config{
+		Command: crosspty.CommandConfig{Argv: []string{"sh", "-c", "echo ready; exec sleep 100"}},
+	}))
+	defer srv.Close()
+
+	ws := dial(t, srv, "")
+	defer ws.Close()
+
+	var frame []byte
+	if err := websocket.Message.Receive(ws, &frame); err != nil || !strings.Contains(string(frame), "ready") {
+		t.Fatalf("expected ready, got %q, %v", frame, err)
+	}
+	websocket.Message.Send(ws, `{"type":"signal","signal":"TERM"}`)
+
+	_, exit := readUntilExit(t, ws)
+	if exit["type"] != "exit" || exit["signal"] != "TERM" {
+		t.Fatalf("unexpected exit message: %v", exit)
+	}
+}
+
+func TestWebtermCloseMessage(t *testing.T) {
+	skipWindows(t)
+	srv := httptest.NewServer(webterm.NewHandler(webterm.Config{
+		Command: crosspty.CommandConfig{Argv: []string{"sh", "-c", "echo ready; exec sleep 100"}},
+	}))
+	defer srv.Close()
+
+	ws := dial(t, srv, "")
+	defer ws.Close()
+
+	var frame []byte
+	websocket.Message.Receive(ws, &frame)
+	websocket.Message.Send(ws, `{"type":"close"}`)
+
+	_, exit := readUntilExit(t, ws)
+	if exit["type"] != "exit" || exit["code"] != -1.0 {
+		t.Fatalf("unexpected exit message: %v", exit)
+	}
+}
+
+func TestWebtermConcurrentSessions(t *testing.T) {
+	skipWindows(t)
+	srv := httptest.NewServer(webterm.NewHandler(webterm.Config{
+		Command: crosspty.CommandConfig{
+			Argv:      []string{"sh", "-c", "echo $CROSSPTY_TEST; sleep 1"},
+			Dir:       t.TempDir(),
+			EnvInject: map[string]string{"CROSSPTY_TEST": "hello"},
+		},
+	}))
+	defer srv.Close()
+
+	const n = 8
+	wss := make([]*websocket.Conn, n)
+	for i := range wss {
+		wss[i] = dial(t, srv, "")
+		defer wss[i].Close()
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for _, ws := range wss {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out strings.Builder
+			for {
+				var f frame
+				if err := frames.Receive(ws, &f); err != nil {
+					errs <- fmt.Errorf("receive: %v (output %q)", err, out.String())
+					return
+				}
+				if !f.binary {
+					break
+				}
+				out.Write(f.data)
+			}
+			if !strings.Contains(out.String(), "hello") {
+				errs <- fmt.Errorf("unexpected output: %q", out.String())
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestWebtermStartError(t *testing.T) {
+	srv := httptest.NewServer(webterm.NewHandler(webterm.Config{
+		Command: crosspty.CommandConfig{Argv: []string{"/nonexistent/crosspty-test"}},
+	}))
+	defer srv.Close()
+
+	ws := dial(t, srv, "")
+	defer ws.Close()
+
+	var msg map[string]any
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if msg["type"] != "error" || msg["message"] == "" {
+		t.Fatalf("unexpected message: %v", msg)
+	}
+}
+
+func TestWebtermRejectsForeignOrigin(t *testing.T) {
+	srv := httptest.NewServer(webterm.NewHandler(webterm.Config{
+		Command: crosspty.CommandConfig{Argv: []string{"sh"}},
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/"
+	if _, err := websocket.Dial(url, "", "http://evil.example"); err == nil {
+		t.Fatalf("expected a foreign origin to be rejected")
+	}
+}
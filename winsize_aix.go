@@ -0,0 +1,19 @@
+package crosspty
+
+import "golang.org/x/sys/unix"
+
+// TIOCSWINSZ overflows the int request of unix.IoctlSetWinsize as a constant;
+// the conversion keeps its bits.
+var tiocswinsz = uint64(unix.TIOCSWINSZ)
+
+func (p *ptyUnix) Resize(sz TermSize) error {
+	// Do not use creackpty.Setsize, see winsize_unix.go.
+	return p.control(func(fd int) error {
+		return unix.IoctlSetWinsize(fd, int(tiocswinsz), &unix.Winsize{
+			Row:    sz.Rows,
+			Col:    sz.Cols,
+			Xpixel: sz.X,
+			Ypixel: sz.Y,
+		})
+	})
+}
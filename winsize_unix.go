@@ -0,0 +1,18 @@
+//go:build unix && !aix
+
+package crosspty
+
+import "golang.org/x/sys/unix"
+
+func (p *ptyUnix) Resize(sz TermSize) error {
+	// Do not use creackpty.Setsize: it calls Fd(), which puts the file back
+	// into blocking mode and breaks deadlines.
+	return p.control(func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{
+			Row:    sz.Rows,
+			Col:    sz.Cols,
+			Xpixel: sz.X,
+			Ypixel: sz.Y,
+		})
+	})
+}